@@ -0,0 +1,291 @@
+package pintu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// AuditEvent is the audit log record, fields are only ever added and
+	// Version changes on any other evolution of the schema
+	AuditEvent struct {
+		Version   int       `json:"version"`
+		Time      time.Time `json:"time"`
+		Type      string    `json:"type"`
+		User      string    `json:"user,omitempty"`
+		Provider  string    `json:"provider,omitempty"`
+		Reason    string    `json:"reason,omitempty"`
+		RequestID string    `json:"request_id,omitempty"`
+		ClientIP  string    `json:"client_ip,omitempty"`
+		UserAgent string    `json:"user_agent,omitempty"`
+		Host      string    `json:"host,omitempty"`
+		Path      string    `json:"path,omitempty"`
+	}
+
+	// AuditSink receives the audit events, Write is called from the request
+	// goroutines and must not block for long
+	AuditSink interface {
+		Write(event *AuditEvent) error
+		Close() error
+	}
+
+	// auditor fans the events out to the sinks of a configuration
+	auditor struct {
+		mu     sync.RWMutex
+		sinks  []AuditSink
+		owned  []AuditSink
+		closed bool
+	}
+
+	fileSink struct {
+		mu   sync.Mutex
+		file *os.File
+	}
+
+	// webhookSink posts the events from a queue so a slow endpoint does not
+	// hold the requests, cancel aborts the posts on close
+	webhookSink struct {
+		url    string
+		client *http.Client
+		queue  chan *AuditEvent
+		done   chan struct{}
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+
+	// contextCloser is implemented by the sinks which may block on close,
+	// they give up when ctx is done
+	contextCloser interface {
+		closeContext(ctx context.Context) error
+	}
+)
+
+const (
+	auditVersion = 1
+
+	AuditLoginSuccess   = "login_success"
+	AuditLoginFailure   = "login_failure"
+	AuditLogout         = "logout"
+	AuditSessionRevoked = "session_revoked"
+	AuditAccessDenied   = "access_denied"
+	AuditLockout        = "lockout"
+	AuditMFASuccess     = "mfa_success"
+	AuditMFAFailure     = "mfa_failure"
+	AuditMFAEnrolled    = "mfa_enrolled"
+	AuditMFAReset       = "mfa_reset"
+	AuditConfigReload   = "config_reload"
+
+	webhookQueueSize  = 1024
+	webhookTimeout    = 5 * time.Second
+	auditCloseTimeout = 10 * time.Second
+)
+
+var (
+	errAuditSink  = errors.New("supported sinks are file:/path, syslog:, syslog+udp://host:port, syslog+tcp://host:port and http(s) urls")
+	errAuditQueue = errors.New("audit webhook queue full, event dropped")
+	errAuditClose = errors.New("audit webhook closed before delivering its queue")
+)
+
+// newAuditor opens the sinks of the audit option, the embedder sinks are
+// shared by every configuration and left open
+func newAuditor(specs []string, shared []AuditSink) (*auditor, error) {
+	var errs ValidationErrors
+	a := &auditor{sinks: append([]AuditSink{}, shared...)}
+	for _, spec := range specs {
+		sink, err := openAuditSink(spec)
+		if err != nil {
+			errs.Add(InvalidParam(optionAudit, err))
+			continue
+		}
+		a.sinks = append(a.sinks, sink)
+		a.owned = append(a.owned, sink)
+	}
+	if err := errs.Err(); err != nil {
+		a.Close(context.Background())
+		return nil, err
+	}
+	return a, nil
+}
+
+func openAuditSink(spec string) (AuditSink, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(spec, "file:"), "//")
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return &fileSink{file: file}, nil
+	case spec == "syslog:" || strings.HasPrefix(spec, "syslog+"):
+		return openSyslogSink(spec)
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return newWebhookSink(spec), nil
+	}
+	return nil, errAuditSink
+}
+
+// emit completes the event with the request details and writes it to every
+// sink, the events of a closed configuration are dropped
+func (a *auditor) emit(r *http.Request, event AuditEvent) {
+	if a == nil {
+		return
+	}
+	event.Version = auditVersion
+	event.Time = time.Now().UTC()
+	if r != nil {
+		if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+			event.RequestID = info.id
+		}
+		event.ClientIP = GetRemoteIP(r)
+		event.UserAgent = r.UserAgent()
+		event.Host = r.Host
+		event.Path = r.URL.Path
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return
+	}
+	for _, sink := range a.sinks {
+		if err := sink.Write(&event); err != nil {
+			Logger().Error("audit event lost", "type", event.Type, "err", err.Error())
+		}
+	}
+}
+
+// Close closes the sinks opened from the configuration, the events still
+// queued when ctx is done are dropped. The new events are dropped at once
+// and the lock is not held while the sinks drain
+func (a *auditor) Close(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.mu.Unlock()
+	var err error
+	for _, sink := range a.owned {
+		var e error
+		if c, ok := sink.(contextCloser); ok {
+			e = c.closeContext(ctx)
+		} else {
+			e = sink.Close()
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Write appends the event as a json line
+func (s *fileSink) Write(event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+func newWebhookSink(url string) *webhookSink {
+	s := &webhookSink{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan *AuditEvent, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run()
+	return s
+}
+
+func (s *webhookSink) Write(event *AuditEvent) error {
+	select {
+	case s.queue <- event:
+		return nil
+	default:
+		return errAuditQueue
+	}
+}
+
+// run posts the queued events, once canceled the rest of the queue is
+// dropped
+func (s *webhookSink) run() {
+	defer close(s.done)
+	dropped := 0
+	for event := range s.queue {
+		if s.ctx.Err() != nil {
+			dropped++
+			continue
+		}
+		if err := s.post(event); err != nil {
+			Logger().Error("audit webhook failed", "type", event.Type, "err", err.Error())
+		}
+	}
+	if dropped > 0 {
+		Logger().Error("audit events dropped on close", "count", dropped)
+	}
+}
+
+func (s *webhookSink) post(event *AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(s.ctx, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close delivers the queued events within auditCloseTimeout then stops
+func (s *webhookSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), auditCloseTimeout)
+	defer cancel()
+	return s.closeContext(ctx)
+}
+
+// closeContext delivers the queued events until ctx is done, the post in
+// flight is then aborted and the rest dropped
+func (s *webhookSink) closeContext(ctx context.Context) error {
+	close(s.queue)
+	select {
+	case <-s.done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+	s.cancel()
+	<-s.done
+	return errAuditClose
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package pintu
+
+import "errors"
+
+func openSyslogSink(spec string) (AuditSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,44 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package pintu
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"strings"
+)
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+// openSyslogSink dials the local syslog for syslog: or a remote one for
+// syslog+udp://host:port and syslog+tcp://host:port
+func openSyslogSink(spec string) (AuditSink, error) {
+	var network, addr string
+	if spec != "syslog:" {
+		parts := strings.SplitN(strings.TrimPrefix(spec, "syslog+"), "://", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errAuditSink
+		}
+		network, addr = parts[0], parts[1]
+	}
+	writer, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTH, "pintu")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Write(event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(line))
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,59 @@
+package pintu
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookCloseDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	sink := newWebhookSink(server.URL)
+	for i := 0; i < 3; i++ {
+		sink.Write(&AuditEvent{Type: AuditLoginSuccess})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := sink.closeContext(ctx); err != errAuditClose {
+		t.Fatalf("closeContext() = %v, want %v", err, errAuditClose)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("closeContext() took %v past its deadline", elapsed)
+	}
+}
+
+func TestAuditorCloseDropsNewEvents(t *testing.T) {
+	posted := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted <- struct{}{}
+	}))
+	defer server.Close()
+
+	a, err := newAuditor([]string{server.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.emit(nil, AuditEvent{Type: AuditLogout})
+	if err := a.Close(context.Background()); err != nil {
+		t.Fatalf("Close() = %v, the queued event was not delivered", err)
+	}
+	<-posted
+	a.emit(nil, AuditEvent{Type: AuditLogout})
+	select {
+	case <-posted:
+		t.Fatal("an event was posted after Close()")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,136 @@
+package pintu
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type (
+	// Identity is the user a provider vouches for
+	Identity struct {
+		Email    string
+		Username string
+		Provider string
+	}
+
+	// Authenticator is the common part of the second generation providers,
+	// the Guard serves their routes, issues the cookie and renders the errors
+	Authenticator interface {
+		ID() string
+		Name() string
+		// Path is the route prefix the Guard mounts the login handlers on
+		Path() string
+		ParseSettings() error
+	}
+
+	// CredentialProvider checks the username and password posted on the
+	// login form, it returns ErrInvalidCredentials on a mismatch
+	CredentialProvider interface {
+		Authenticator
+		Authenticate(ctx context.Context, username, password string) (*Identity, error)
+	}
+
+	// RedirectProvider sends the visitor to an external login portal which
+	// comes back to the callback url with the state untouched in its state
+	// parameter, the Guard checks it before CompleteLogin
+	RedirectProvider interface {
+		Authenticator
+		BeginLogin(r *http.Request, callback, state string) (string, error)
+		CompleteLogin(r *http.Request, callback string) (identity *Identity, state string, err error)
+	}
+
+	// RequestProvider authenticates every request on its own, ie from the tls
+	// client certificate, for the callers which can not keep a cookie, it
+	// returns a nil identity when the request carries no credentials
+	RequestProvider interface {
+		Authenticator
+		AuthenticateRequest(r *http.Request) (*Identity, error)
+	}
+
+	// ClientCertificates is optionally implemented by request providers
+	// reading the tls client certificate, the https listener then asks the
+	// clients for one
+	ClientCertificates interface {
+		ClientCertificates() bool
+	}
+
+	// Button is optionally implemented by redirect providers to style their
+	// login link with bootstrap-social classes
+	Button interface {
+		Button() (class, icon string)
+	}
+
+	// authProvider adapts an Authenticator to the Provider interface so both
+	// generations are mounted the same way
+	authProvider struct {
+		Authenticator
+		ptype string
+	}
+)
+
+const (
+	startAction    = "start"
+	callbackAction = "callback"
+)
+
+// AdaptAuthenticator wraps a CredentialProvider, a RedirectProvider or a
+// RequestProvider into a Provider, any other Authenticator is a programming
+// error
+func AdaptAuthenticator(a Authenticator) Provider {
+	switch a.(type) {
+	case CredentialProvider:
+		return &authProvider{Authenticator: a, ptype: "form"}
+	case RedirectProvider:
+		return &authProvider{Authenticator: a, ptype: "link"}
+	case RequestProvider:
+		return &authProvider{Authenticator: a, ptype: "request"}
+	}
+	panic(fmt.Sprintf("pintu: %T is neither a CredentialProvider, a RedirectProvider nor a RequestProvider", a))
+}
+
+// RegisterAuthenticator is the RegisterProvider counterpart for second
+// generation providers
+func RegisterAuthenticator(ptype string, factory func(opts *OptionSet) Authenticator) {
+	RegisterProvider(ptype, func(opts *OptionSet) Provider {
+		return AdaptAuthenticator(factory(opts))
+	})
+}
+
+// RegisterCookie is a no-op, the Guard issues the cookie
+func (p *authProvider) RegisterCookie(*CookieFactory) {}
+
+func (p *authProvider) RegisterHandler(g *Guard) {
+	switch a := p.Authenticator.(type) {
+	case CredentialProvider:
+		g.HandleFunc(a.Path()+"/"+startAction, g.credentialHandler(a))
+	case RedirectProvider:
+		g.HandleFunc(a.Path()+"/"+startAction, g.beginLoginHandler(a))
+		g.HandleFunc(a.Path()+"/"+callbackAction, g.completeLoginHandler(a))
+	case RequestProvider:
+		g.requestProviders = append(g.requestProviders, a)
+	}
+}
+
+func (p *authProvider) Partial(r *http.Request) string {
+	if p.ptype == "request" {
+		// nothing to click, the credentials come with the request
+		return ""
+	}
+	partial := &LoginPartial{
+		Action:   GetHostPath(r, p.Path()+"/"+startAction),
+		Redirect: r.URL.RequestURI(),
+		Name:     p.Name(),
+	}
+	if p.ptype == "form" {
+		return partial.GetForm(r)
+	}
+	if b, ok := p.Authenticator.(Button); ok {
+		partial.Type, partial.Btn = b.Button()
+	}
+	return partial.GetLink(r)
+}
+
+func (p *authProvider) Type() string {
+	return p.ptype
+}
@@ -0,0 +1,9 @@
+//go:build ldap && cgo
+// +build ldap,cgo
+
+package main
+
+// The LDAP provider binds against libldap through cgo and
+// github.com/mqu/openldap, it is only linked in by a build with the ldap tag
+
+import _ "github.com/Tuxuri/pintu/provider/ldap"
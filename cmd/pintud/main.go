@@ -1,26 +1,44 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
 
 	"github.com/Tuxuri/pintu"
-	"github.com/Tuxuri/pintu/provider/google"
-	"github.com/Tuxuri/pintu/provider/htpasswd"
-	"github.com/Tuxuri/pintu/provider/ldap"
+	_ "github.com/Tuxuri/pintu/provider/google"
+	_ "github.com/Tuxuri/pintu/provider/htpasswd"
+	_ "github.com/Tuxuri/pintu/provider/mtls"
+	_ "github.com/Tuxuri/pintu/store/redis"
 )
 
 var buildVersion string
 
 func main() {
-	fmt.Printf("pintud%s\n", buildVersion)
+	args := os.Args[1:]
 
-	ldap := ldap.NewLdapProvider()
-	google := google.NewGoogleOauthProvider()
-	htpasswd := htpasswd.NewHtpasswdProvider()
+	// pintud config print [options] shows the effective configuration
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		server := pintu.NewPintu(pintu.Options{
+			FlagSet: flag.NewFlagSet("pintud config print", flag.ExitOnError),
+			Args:    args[2:],
+		})
+		if err := server.PrintConfig(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	server := pintu.NewPintu()
-	server.Use(htpasswd)
-	server.Use(ldap)
-	server.Use(google)
+	server := pintu.NewPintu(pintu.Options{
+		FlagSet: flag.NewFlagSet("pintud", flag.ExitOnError),
+		Args:    args,
+		Version: buildVersion,
+	})
+	if len(args) == 1 && args[0] == "version" {
+		info := server.BuildInfo()
+		fmt.Printf("pintud %s %s %s\n", info.Version, info.Revision, info.GoVersion)
+		return
+	}
 	server.Run()
 }
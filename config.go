@@ -0,0 +1,228 @@
+package pintu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// Config is the content of the --config file, top level keys are the
+	// pintu options and the sections below are kept apart
+	//
+	//	upstream: http://127.0.0.1:8080
+	//	providers:
+	//	  - type: ldap
+	//	    id: corp
+	//	    options:
+	//	      ldap_server: ldap://127.0.0.1:389
+	//	headers:
+	//	  X-Environment: production
+	//	rules:
+	//	  - path: /admin
+	//	    domains: [example.com]
+	//	upstreams:
+	//	  - host: grafana.example.com
+	//	    url: http://127.0.0.1:3000
+	//	  - path: /kibana/
+	//	    url: http://127.0.0.1:5601
+	//	    strip_prefix: true
+	Config struct {
+		Options   map[string]interface{}
+		Providers []ProviderConfig
+		Headers   map[string]string
+		Rules     Rules
+		Upstreams Upstreams
+	}
+
+	// ProviderConfig is the section of one provider instance, its options
+	// are named without the instance prefix
+	ProviderConfig struct {
+		Type    string                 `json:"type"`
+		ID      string                 `json:"id"`
+		Options map[string]interface{} `json:"options"`
+	}
+)
+
+const (
+	configProviders = "providers"
+	configHeaders   = "headers"
+	configRules     = "rules"
+	configUpstreams = "upstreams"
+)
+
+// LoadConfig reads and validates a json, yaml or toml config file, the
+// format follows the file extension, an empty path gives an empty config
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{Options: make(map[string]interface{})}
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, InvalidParam(optionConfig, err)
+	}
+
+	var raw interface{}
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		var table map[string]interface{}
+		err = toml.Unmarshal(data, &table)
+		raw = table
+	default:
+		err = fmt.Errorf("unsupported config format %q", ext)
+	}
+	if err != nil {
+		return config, InvalidParam(optionConfig, err)
+	}
+
+	root, ok := normalizeConfig(raw).(map[string]interface{})
+	if !ok && raw != nil {
+		return config, InvalidParam(optionConfig, fmt.Errorf("%s is not a mapping", path))
+	}
+
+	keys := make([]string, 0, len(root))
+	for key := range root {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs ValidationErrors
+	for _, key := range keys {
+		value := root[key]
+		switch key {
+		case configProviders:
+			errs.Add(decodeSection(key, value, &config.Providers))
+		case configHeaders:
+			errs.Add(decodeSection(key, value, &config.Headers))
+		case configRules:
+			errs.Add(decodeSection(key, value, &config.Rules))
+		case configUpstreams:
+			errs.Add(decodeSection(key, value, &config.Upstreams))
+		default:
+			config.Options[key] = value
+		}
+	}
+	errs.Add(config.validate())
+	return config, errs.Err()
+}
+
+// configPath looks up the config file ahead of the flags parsing, it decides
+// the provider instances to build
+func (o Options) configPath() string {
+	if o.FlagSet != nil {
+		if values := lookupArgs(o.Args, optionConfig); len(values) > 0 {
+			return values[len(values)-1]
+		}
+	}
+	if o.ConfigFile != "" {
+		return o.ConfigFile
+	}
+	path, _ := LookupEnv(optionConfig)
+	return path
+}
+
+// ProviderSpecs lists the provider instances of the config file
+func (c *Config) ProviderSpecs() StringSlice {
+	var specs StringSlice
+	for _, p := range c.Providers {
+		spec := p.Type
+		if p.ID != "" {
+			spec += ":" + p.ID
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// ProviderOptions returns the options section of a provider instance
+func (c *Config) ProviderOptions(ptype, id string) map[string]interface{} {
+	for _, p := range c.Providers {
+		if p.Type == ptype && p.ID == id {
+			return p.Options
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	var errs ValidationErrors
+	seen := make(map[string]bool)
+	for _, spec := range c.ProviderSpecs() {
+		ptype, id, err := ParseProviderSpec(spec)
+		if err == nil {
+			_, err = lookupProvider(ptype)
+		}
+		if err == nil && seen[ptype+":"+id] {
+			err = fmt.Errorf("provider %s configured twice", spec)
+		}
+		if err != nil {
+			errs.Add(InvalidParam(configProviders, err))
+		}
+		seen[ptype+":"+id] = true
+	}
+	for name := range c.Headers {
+		if name == "" {
+			errs.Add(InvalidParam(configHeaders, errEmptyHeader))
+		}
+	}
+	errs.Add(c.Rules.validate())
+	return errs.Err()
+}
+
+// decodeSection maps a generic section onto its typed form, unknown keys
+// are rejected
+func decodeSection(key string, value interface{}, v interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return InvalidParam(key, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return InvalidParam(key, err)
+	}
+	return nil
+}
+
+// normalizeConfig turns the yaml map[interface{}]interface{} mappings into
+// map[string]interface{} so every format decodes the same way
+func normalizeConfig(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeConfig(item)
+		}
+		return m
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeConfig(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeConfig(item)
+		}
+		return v
+	case []map[string]interface{}:
+		// toml arrays of tables
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = normalizeConfig(item)
+		}
+		return items
+	}
+	return value
+}
@@ -0,0 +1,188 @@
+package pintu
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configStub is the "stub" provider type, its instances require stub_realm
+type configStub struct {
+	id    string
+	realm string
+	opts  *OptionSet
+}
+
+func init() {
+	RegisterAuthenticator("stub", func(opts *OptionSet) Authenticator {
+		p := &configStub{id: opts.ID(), opts: opts}
+		opts.StringVar(&p.realm, "stub_realm", "", "realm of the stub provider")
+		return p
+	})
+}
+
+func (p *configStub) ID() string   { return p.id }
+func (p *configStub) Name() string { return InstanceName("Stub", p.id) }
+func (p *configStub) Path() string { return InstancePath("/auth/stub", p.id) }
+
+func (p *configStub) ParseSettings() error {
+	if err := p.opts.Parse(); err != nil {
+		return err
+	}
+	if p.realm == "" {
+		return MissingParam(p.opts.Name("stub_realm"))
+	}
+	return nil
+}
+
+func (p *configStub) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	return nil, ErrInvalidCredentials
+}
+
+// writeConfig writes content to a temporary config file named name
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var sameConfigs = map[string]string{
+	"pintu.json": `{
+  "upstream": "http://127.0.0.1:8080",
+  "cookie_secret": "not so secret",
+  "cookie_expiry": 2,
+  "providers": [{"type": "stub", "id": "corp", "options": {"stub_realm": "corp"}}],
+  "headers": {"X-Environment": "production"},
+  "rules": [{"path": "/admin", "emails": ["alice@example.com"], "mfa": false}],
+  "upstreams": [{
+    "path": "/api",
+    "urls": ["http://127.0.0.1:8081", "http://127.0.0.1:8082"],
+    "fail_timeout": "5s",
+    "health_check": {"path": "/healthz", "interval": 3}
+  }]
+}`,
+	"pintu.yaml": `
+upstream: http://127.0.0.1:8080
+cookie_secret: not so secret
+cookie_expiry: 2
+providers:
+  - type: stub
+    id: corp
+    options:
+      stub_realm: corp
+headers:
+  X-Environment: production
+rules:
+  - path: /admin
+    emails: [alice@example.com]
+    mfa: false
+upstreams:
+  - path: /api
+    urls: [http://127.0.0.1:8081, http://127.0.0.1:8082]
+    fail_timeout: 5s
+    health_check:
+      path: /healthz
+      interval: 3
+`,
+	"pintu.toml": `
+upstream = "http://127.0.0.1:8080"
+cookie_secret = "not so secret"
+cookie_expiry = 2
+
+[headers]
+X-Environment = "production"
+
+[[providers]]
+type = "stub"
+id = "corp"
+[providers.options]
+stub_realm = "corp"
+
+[[rules]]
+path = "/admin"
+emails = ["alice@example.com"]
+mfa = false
+
+[[upstreams]]
+path = "/api"
+urls = ["http://127.0.0.1:8081", "http://127.0.0.1:8082"]
+fail_timeout = "5s"
+[upstreams.health_check]
+path = "/healthz"
+interval = 3
+`,
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	for name, content := range sameConfigs {
+		config, err := LoadConfig(writeConfig(t, name, content))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if specs := config.ProviderSpecs(); len(specs) != 1 || specs[0] != "stub:corp" {
+			t.Errorf("%s: providers %v", name, specs)
+		}
+		if realm := config.ProviderOptions("stub", "corp")["stub_realm"]; realm != "corp" {
+			t.Errorf("%s: provider options %v", name, config.ProviderOptions("stub", "corp"))
+		}
+		if config.Headers["X-Environment"] != "production" {
+			t.Errorf("%s: headers %v", name, config.Headers)
+		}
+		if len(config.Rules) != 1 || config.Rules[0].Path != "/admin" || config.Rules[0].Emails[0] != "alice@example.com" {
+			t.Errorf("%s: rules %+v", name, config.Rules)
+		}
+		if len(config.Upstreams) != 1 {
+			t.Errorf("%s: upstreams %v", name, config.Upstreams)
+			continue
+		}
+		u := config.Upstreams[0]
+		if len(u.URLs) != 2 || time.Duration(u.FailTimeout) != 5*time.Second ||
+			u.HealthCheck == nil || u.HealthCheck.Path != "/healthz" || time.Duration(u.HealthCheck.Interval) != 3*time.Second {
+			t.Errorf("%s: upstream %+v", name, u)
+		}
+	}
+}
+
+func TestConfigSettings(t *testing.T) {
+	for name, content := range sameConfigs {
+		p := NewPintu(Options{ConfigFile: writeConfig(t, name, content)})
+		if err := p.load(p.options); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		rt := p.runtime()
+		if rt.settings.Upstream != "http://127.0.0.1:8080" || rt.settings.CookieExpiry != 2 || rt.settings.CookieSecret != "not so secret" {
+			t.Errorf("%s: settings %+v", name, rt.settings)
+		}
+		rt.settings.routes.Close()
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, content, err string
+	}{
+		{"pintu.ini", "upstream=x", "unsupported config format"},
+		{"pintu.json", "[1, 2]", "is not a mapping"},
+		{"pintu.yaml", "upstream: [", "yaml"},
+		{"pintu.toml", "upstream = ", "toml"},
+		{"pintu.yaml", "rules:\n  - path: /admin\n    users: [alice]\n", "unknown field"},
+		{"pintu.yaml", "rules:\n  - path: admin\n    emails: [alice@example.com]\n", errRulePath.Error()},
+		{"pintu.json", `{"providers": [{"type": "nope"}]}`, "unknown provider type"},
+		{"pintu.json", `{"providers": [{"type": "stub"}, {"type": "stub"}]}`, "configured twice"},
+		{"pintu.toml", "[headers]\n\"\" = \"x\"\n", errEmptyHeader.Error()},
+	}
+	for _, test := range tests {
+		_, err := LoadConfig(writeConfig(t, test.name, test.content))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("LoadConfig(%s %q) = %v, want %q", test.name, test.content, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package pintu
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	csrfField  = "csrf_token"
+	csrfSuffix = "_csrf"
+)
+
+type csrfKey struct{}
+
+// csrfCookie returns the nonce of the pre-auth cookie, a new one is issued
+// when the visitor has none yet, it lasts for the browser session
+func (c *CookieFactory) csrfCookie(w http.ResponseWriter, req *http.Request) string {
+	if cookie, err := req.Cookie(c.key + csrfSuffix); err == nil && len(cookie.Value) == 43 {
+		return cookie.Value
+	}
+	nonce := make([]byte, 32)
+	rand.Read(nonce)
+	value := base64.RawURLEncoding.EncodeToString(nonce)
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.key + csrfSuffix,
+		Value:    value,
+		Path:     "/",
+		Domain:   GetDomain(req),
+		HttpOnly: true,
+		Secure:   IsSecured(req),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return value
+}
+
+// csrfToken binds the form token to the cookie nonce, a forged page can
+// neither read the cookie nor compute the token of a planted one
+func (c *CookieFactory) csrfToken(nonce string) string {
+	h := hmac.New(sha256.New, []byte(c.secret))
+	h.Write([]byte(c.key + csrfSuffix))
+	h.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// ValidateCSRF checks the token posted with a login form against the
+// pre-auth cookie
+func (c *CookieFactory) ValidateCSRF(req *http.Request) bool {
+	cookie, err := req.Cookie(c.key + csrfSuffix)
+	if err != nil {
+		return false
+	}
+	token := req.PostFormValue(csrfField)
+	return token != "" && hmac.Equal([]byte(token), []byte(c.csrfToken(cookie.Value)))
+}
+
+// csrfState is the oauth state of a redirect login, the token of the
+// pre-auth cookie followed by where to go once logged in
+func (c *CookieFactory) csrfState(w http.ResponseWriter, req *http.Request, redirect string) string {
+	return c.csrfToken(c.csrfCookie(w, req)) + ":" + redirect
+}
+
+// validateCSRFState checks the state given back to the callback against
+// the pre-auth cookie and returns its redirect, the callback of a login
+// begun in another browser is refused
+func (c *CookieFactory) validateCSRFState(req *http.Request, state string) (string, bool) {
+	cookie, err := req.Cookie(c.key + csrfSuffix)
+	if err != nil {
+		return "", false
+	}
+	token, redirect, ok := strings.Cut(state, ":")
+	if !ok || !hmac.Equal([]byte(token), []byte(c.csrfToken(cookie.Value))) {
+		return "", false
+	}
+	return redirect, true
+}
+
+// ClearCSRFCookie drops the pre-auth cookie once logged in
+func (c *CookieFactory) ClearCSRFCookie(w http.ResponseWriter, req *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.key + csrfSuffix,
+		Value:    "",
+		Path:     "/",
+		Domain:   GetDomain(req),
+		Expires:  time.Now().Add(time.Duration(1) * time.Hour * -1),
+		HttpOnly: true,
+	})
+}
+
+// withCSRFToken issues the pre-auth cookie and passes its form token on to
+// the login partials
+func (c *CookieFactory) withCSRFToken(w http.ResponseWriter, req *http.Request) *http.Request {
+	token := c.csrfToken(c.csrfCookie(w, req))
+	return req.WithContext(context.WithValue(req.Context(), csrfKey{}, token))
+}
+
+// CSRFToken returns the form token of the login page being rendered, the
+// providers rendering their own forms post it as csrf_token
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
@@ -0,0 +1,136 @@
+package pintu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// csrfCookieOf issues a pre-auth cookie and returns it
+func csrfCookieOf(t *testing.T, c *CookieFactory) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c.csrfCookie(w, httptest.NewRequest("GET", loginPromptPath, nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("csrfCookie() set %d cookies", len(cookies))
+	}
+	return cookies[0]
+}
+
+func csrfPost(cookie *http.Cookie, token string) *http.Request {
+	form := url.Values{csrfField: {token}}
+	r := httptest.NewRequest("POST", "/auth/htpasswd/start", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestValidateCSRF(t *testing.T) {
+	c := NewCookieFactory("_pintu", "secret", 1)
+	cookie := csrfCookieOf(t, c)
+	token := c.csrfToken(cookie.Value)
+
+	// a new login page issues a new nonce once the cookie is cleared
+	rotated := csrfCookieOf(t, c)
+	other := NewCookieFactory("_pintu", "other secret", 1)
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		token  string
+		ok     bool
+	}{
+		{"matching", cookie, token, true},
+		{"missing cookie", nil, token, false},
+		{"missing token", cookie, "", false},
+		{"reused after the cookie rotated", rotated, token, false},
+		{"token of another secret", cookie, other.csrfToken(cookie.Value), false},
+		{"cookie value as token", cookie, cookie.Value, false},
+	}
+	for _, test := range tests {
+		if ok := c.ValidateCSRF(csrfPost(test.cookie, test.token)); ok != test.ok {
+			t.Errorf("%s: ValidateCSRF() = %t, want %t", test.name, ok, test.ok)
+		}
+	}
+}
+
+func TestCSRFCookieReused(t *testing.T) {
+	c := NewCookieFactory("_pintu", "secret", 1)
+	cookie := csrfCookieOf(t, c)
+	r := httptest.NewRequest("GET", loginPromptPath, nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	if nonce := c.csrfCookie(w, r); nonce != cookie.Value || len(w.Result().Cookies()) != 0 {
+		t.Fatal("csrfCookie() replaced a valid pre-auth cookie")
+	}
+}
+
+// redirectStub is a RedirectProvider echoing the state of the callback and
+// counting the redeemed codes
+type redirectStub struct {
+	redeemed *int
+}
+
+func (redirectStub) ID() string           { return "" }
+func (redirectStub) Name() string         { return "Stub" }
+func (redirectStub) Path() string         { return "/oauth2/stub" }
+func (redirectStub) ParseSettings() error { return nil }
+
+func (redirectStub) BeginLogin(r *http.Request, callback, state string) (string, error) {
+	return "https://idp.example.com/auth?state=" + url.QueryEscape(state), nil
+}
+
+func (s redirectStub) CompleteLogin(r *http.Request, callback string) (*Identity, string, error) {
+	*s.redeemed++
+	return &Identity{Email: "alice@example.com"}, r.Form.Get("state"), nil
+}
+
+func TestRedirectLoginState(t *testing.T) {
+	g := newTestGuard()
+	var redeemed int
+	if err := g.Use(AdaptAuthenticator(redirectStub{redeemed: &redeemed})); err != nil {
+		t.Fatal(err)
+	}
+	begin := func() (*http.Cookie, string) {
+		w := httptest.NewRecorder()
+		g.mux.ServeHTTP(w, httptest.NewRequest("GET", "/oauth2/stub/start?rd=%2Fapp", nil))
+		location, _ := url.Parse(w.Header().Get("Location"))
+		return w.Result().Cookies()[0], location.Query().Get("state")
+	}
+	callback := func(cookie *http.Cookie, state string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/oauth2/stub/callback?code=x&state="+url.QueryEscape(state), nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		g.mux.ServeHTTP(w, r)
+		return w
+	}
+
+	victim, _ := begin()
+	attacker, state := begin()
+	if !strings.HasSuffix(state, ":/app") {
+		t.Fatalf("state %q does not carry the redirect", state)
+	}
+	if w := callback(victim, state); w.Code != http.StatusForbidden {
+		t.Fatalf("callback with the state of another browser got %d, want 403", w.Code)
+	}
+	if w := callback(nil, state); w.Code != http.StatusForbidden {
+		t.Fatalf("callback without pre-auth cookie got %d, want 403", w.Code)
+	}
+	if w := callback(attacker, "/app"); w.Code != http.StatusForbidden {
+		t.Fatalf("callback with a bare redirect state got %d, want 403", w.Code)
+	}
+	if redeemed != 0 {
+		t.Fatalf("the forged callbacks redeemed %d codes", redeemed)
+	}
+	w := callback(attacker, state)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/app" {
+		t.Fatalf("callback got %d to %q, want the login", w.Code, w.Header().Get("Location"))
+	}
+}
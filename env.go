@@ -0,0 +1,36 @@
+package pintu
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// EnvPrefix namespaces the environment variables of pintu
+const EnvPrefix = "PINTU_"
+
+var deprecatedEnv sync.Map
+
+// EnvName returns the environment variable of an option, ie PINTU_UPSTREAM
+// for upstream and PINTU_CORP_LDAP_SERVER for corp_ldap_server, the dashes
+// of the provider ids become underscores
+func EnvName(option string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// LookupEnv reads the environment variable of an option and returns the
+// variable it was found in, the legacy unprefixed name is still read with
+// a deprecation warning
+func LookupEnv(option string) (value, name string) {
+	name = EnvName(option)
+	if value = os.Getenv(name); value != "" {
+		return value, name
+	}
+	if value = os.Getenv(option); value != "" {
+		if _, warned := deprecatedEnv.LoadOrStore(option, true); !warned {
+			Logger().Warn("environment variable is deprecated", "variable", option, "use", name)
+		}
+		return value, option
+	}
+	return "", ""
+}
@@ -2,24 +2,96 @@ package pintu
 
 import (
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
+	"strings"
 )
 
-type ErrorResponse struct {
-	Title     string
-	Message   string
-	LoginPath string
-}
+type (
+	ErrorResponse struct {
+		Title     string
+		Message   string
+		LoginPath string
+	}
+
+	// ValidationError reports a missing or invalid configuration option
+	ValidationError struct {
+		Option string
+		Err    error
+	}
+
+	// ValidationErrors aggregates every settings problem so they are
+	// reported at once
+	ValidationErrors []error
+)
 
 var (
 	ErrInvalidCredentials = errors.New("Invalid credentials")
 	ErrAuthServerDown     = errors.New("Authentication server offline")
+	ErrAccessDenied       = errors.New("Access denied")
+	ErrMissingParam       = errors.New("missing param")
+	ErrTooManyAttempts    = errors.New("Too many failed attempts, retry later")
+	ErrInvalidCSRF        = errors.New("The login form expired, please try again")
 )
 
+// BackendError marks err as a failure of the provider backend, ie an
+// unreachable server or a failed token exchange, rather than a rejected login
+func BackendError(err error) error {
+	return fmt.Errorf("%w: %s", ErrAuthServerDown, err.Error())
+}
+
+// MissingParam reports a required option left empty
+func MissingParam(option string) error {
+	return &ValidationError{Option: option, Err: ErrMissingParam}
+}
+
+// InvalidParam reports an option which value is rejected
+func InvalidParam(option string, err error) error {
+	return &ValidationError{Option: option, Err: err}
+}
+
+func (e *ValidationError) Error() string {
+	if e.Err == ErrMissingParam {
+		return fmt.Sprintf("missing param %s", e.Option)
+	}
+	return fmt.Sprintf("invalid param %s: %s", e.Option, e.Err.Error())
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Add appends err unless it is nil, aggregated errors are flattened
+func (e *ValidationErrors) Add(err error) {
+	if err == nil {
+		return
+	}
+	if errs, ok := err.(ValidationErrors); ok {
+		*e = append(*e, errs...)
+		return
+	}
+	*e = append(*e, err)
+}
+
+// Err returns nil when nothing was reported
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid settings:\n  %s", strings.Join(messages, "\n  "))
+}
+
 // Error compiles error response
 func DefaultError(w http.ResponseWriter, r *http.Request, code int, title string, message string) {
-	log.Printf("ErrorPage %d %s %s", code, title, message)
+	RequestLogger(r).Debug("error page", "status", code, "title", title, "message", message)
 
 	w.WriteHeader(code)
 
@@ -1,9 +1,15 @@
 package pintu
 
 import (
+	"fmt"
 	"html/template"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
@@ -11,7 +17,20 @@ type (
 		mux           *http.ServeMux
 		cookieFactory *CookieFactory
 		template      *template.Template
-		providers     map[string]Provider
+		providers     []Provider
+		headers       map[string]string
+		rules         Rules
+		upstreams     Upstreams
+		// requestProviders are tried in order on requests without cookie
+		requestProviders []RequestProvider
+		sessions         SessionStore
+		metrics          *metrics
+		audit            *auditor
+		limiter          *loginLimiter
+		// mfa is nil when the second factor is off
+		mfa *mfa
+		// streamCheck is the session check interval of the streams
+		streamCheck time.Duration
 	}
 )
 
@@ -19,58 +38,217 @@ func NewGuard() *Guard {
 	mux := http.NewServeMux()
 	t := GetTemplates()
 	guard := &Guard{
-		mux:       mux,
-		template:  t,
-		providers: make(map[string]Provider),
+		mux:      mux,
+		template: t,
+		sessions: NewMemoryStore(),
 	}
 	mux.HandleFunc(loginPromptPath, guard.LoginPrompt)
 	return guard
 }
 
-func (g *Guard) Use(providers ...Provider) {
+// Use mounts the providers, the login page lists them in the same order
+// The settings errors of every provider are returned together
+func (g *Guard) Use(providers ...Provider) error {
+	var errs ValidationErrors
 	for _, p := range providers {
+		if g.mounted(p.Name()) {
+			errs.Add(fmt.Errorf("provider %s enabled twice", p.Name()))
+			continue
+		}
+		if err := p.ParseSettings(); err != nil {
+			errs.Add(err)
+			continue
+		}
 		p.RegisterCookie(g.cookieFactory)
 		p.RegisterHandler(g)
-		p.ParseSettings()
-		g.providers[p.Name()] = p
+		g.providers = append(g.providers, p)
+	}
+	return errs.Err()
+}
+
+func (g *Guard) mounted(name string) bool {
+	for _, p := range g.providers {
+		if p.Name() == name {
+			return true
+		}
 	}
+	return false
 }
 
 func (g *Guard) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
 	g.mux.HandleFunc(pattern, handler)
 }
 
-func (g *Guard) CheckCookie(r *http.Request) (email string, ok bool) {
+// Session returns the live session the request cookie points to
+func (g *Guard) Session(r *http.Request) (*Session, bool) {
+	ctx, span := StartSpan(r.Context(), "Guard.Session")
+	session, result, err := g.session(r.WithContext(ctx))
+	span.SetAttributes(attribute.String("pintu.cookie", result))
+	EndSpan(span, err)
+	g.metrics.cookie(result)
+	return session, result == cookieValid
+}
+
+// session validates the cookie of r and loads its session, err is the
+// store failure
+func (g *Guard) session(r *http.Request) (*Session, string, error) {
 	cookie, err := r.Cookie(g.cookieFactory.key)
-	if err == nil {
-		email, ok = g.cookieFactory.ValidateCookie(cookie)
+	if err != nil {
+		return nil, cookieMissing, nil
 	}
-	return email, ok
+	id, ok := g.cookieFactory.ValidateCookie(cookie)
+	if !ok {
+		return nil, cookieInvalid, nil
+	}
+	session, err := g.sessions.Load(r.Context(), id)
+	if err == ErrSessionNotFound {
+		return nil, cookieUnknown, nil
+	}
+	if err != nil {
+		RequestLogger(r).Error("session lookup failed", "err", err.Error())
+		return nil, cookieError, err
+	}
+	return session, cookieValid, nil
+}
+
+func (g *Guard) CheckCookie(r *http.Request) (email string, ok bool) {
+	session, ok := g.Session(r)
+	if !ok {
+		return "", false
+	}
+	return session.Email, true
 }
 
 func (g *Guard) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	r, end := traceRequest(w, r, "Guard.ServeHTTP")
+	defer end()
 	r.Header.Del("X-Forwarded-Email")
-	email, ok := g.CheckCookie(r)
+	if r.URL.Path == logoutPath {
+		g.Logout(w, r)
+		return
+	}
+
+	var email string
+	session, ok := g.Session(r)
+	if ok {
+		email = session.Email
+		if strings.HasPrefix(r.URL.Path, mfaPath) {
+			g.serveMFA(w, r, session)
+			return
+		}
+		if session.Pending && g.mfa != nil {
+			g.requireMFA(w, r)
+			return
+		}
+		if session.Pending {
+			// a reload turned the second factor off since the login
+			session.Pending = false
+			if err := g.sessions.Save(r.Context(), session); err != nil {
+				RequestLogger(r).Error("session update failed", "err", err.Error())
+			}
+		}
+	} else {
+		identity, err := g.authenticateRequest(r)
+		if err != nil {
+			RequestLogger(r).Warn("request authentication failed", "err", err.Error())
+			g.audit.emit(r, AuditEvent{Type: AuditAccessDenied, Reason: err.Error()})
+			Denied(w, r)
+			return
+		}
+		if identity != nil {
+			email, ok = identity.Email, true
+		}
+	}
 
 	if !ok {
 		_, pattern := g.mux.Handler(r)
-		if pattern == "" {
-			log.Println("Please login")
+		if pattern == "" && isStream(r) {
+			// a websocket or event source can not follow the login page
+			DefaultError(w, r, http.StatusUnauthorized, "Unauthorized", "Please login")
+		} else if pattern == "" {
 			g.LoginPrompt(w, r)
 		} else {
-			log.Println("You're free to go")
 			g.mux.ServeHTTP(w, r)
 		}
 		return
 	}
 
+	if !g.rules.Allow(r.URL.Path, email) || !g.upstreams.Allow(r, email) {
+		RequestLogger(r).Info("access denied", "user", email, "path", r.URL.Path)
+		g.audit.emit(r, AuditEvent{Type: AuditAccessDenied, User: email, Reason: "rules"})
+		Denied(w, r)
+		return
+	}
+	if (session == nil || !session.MFA) && (g.rules.RequireMFA(r.URL.Path) || g.upstreams.RequireMFA(r)) {
+		if session == nil {
+			// the request providers identities can not give a second factor
+			RequestLogger(r).Info("access denied", "user", email, "path", r.URL.Path, "err", ErrMFARequired.Error())
+			g.audit.emit(r, AuditEvent{Type: AuditAccessDenied, User: email, Reason: "mfa"})
+			Denied(w, r)
+			return
+		}
+		g.requireMFA(w, r)
+		return
+	}
+
+	setRequestUser(r, email)
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("enduser.id", email))
 	r.Header.Add("X-Forwarded-Email", email)
+	for name, value := range g.headers {
+		r.Header.Set(name, value)
+	}
+	if isStream(r) {
+		r = g.watchSession(r, session)
+	}
 	next(w, r)
 }
 
+// Logout revokes the session of the visitor and goes back to the login page
+func (g *Guard) Logout(w http.ResponseWriter, r *http.Request) {
+	if session, ok := g.Session(r); ok {
+		if err := g.sessions.Delete(r.Context(), session.ID); err != nil {
+			CustomError(w, r, err)
+			return
+		}
+		RequestLogger(r).Info("logged out", "user", session.Email)
+		g.audit.emit(r, AuditEvent{Type: AuditLogout, User: session.Email, Provider: session.Provider})
+	}
+	g.cookieFactory.ClearCookie(w, r)
+	http.Redirect(w, r, loginPromptPath, 302)
+}
+
+// authenticateRequest returns the identity vouched for by the first request
+// provider recognizing the request credentials
+func (g *Guard) authenticateRequest(r *http.Request) (*Identity, error) {
+	for _, p := range g.requestProviders {
+		ctx, span := StartSpan(r.Context(), "provider.authenticate_request", attribute.String("pintu.provider", p.Name()))
+		identity, err := p.AuthenticateRequest(r.WithContext(ctx))
+		EndSpan(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("with %s: %s", p.Name(), err.Error())
+		}
+		if identity != nil {
+			return identity, nil
+		}
+	}
+	return nil, nil
+}
+
+// clientCertificates tells whether a provider reads the tls client
+// certificate
+func (g *Guard) clientCertificates() bool {
+	for _, p := range g.requestProviders {
+		if c, ok := p.(ClientCertificates); ok && c.ClientCertificates() {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *Guard) LoginPrompt(w http.ResponseWriter, r *http.Request) {
 	r.Header.Del("X-Forwarded-Email")
 	g.cookieFactory.ClearCookie(w, r)
+	r = g.cookieFactory.withCSRFToken(w, r)
 
 	partials := ""
 	for _, p := range g.providers {
@@ -78,3 +256,144 @@ func (g *Guard) LoginPrompt(w http.ResponseWriter, r *http.Request) {
 	}
 	g.template.ExecuteTemplate(w, "login.html", template.HTML(partials))
 }
+
+// credentialHandler serves the login form post of a CredentialProvider
+func (g *Guard) credentialHandler(p CredentialProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			CustomError(w, r, err)
+			return
+		}
+		username := r.Form.Get("username")
+		password := r.Form.Get("password")
+		redirect := GetRedirect(r)
+		if !g.cookieFactory.ValidateCSRF(r) {
+			g.loginError(w, r, p, username, ErrInvalidCSRF)
+			return
+		}
+
+		attempt, wait := g.limiter.reserve(r.Context(), GetRemoteIP(r), username)
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+			g.loginError(w, r, p, username, ErrTooManyAttempts)
+			return
+		}
+		ctx, span := StartSpan(r.Context(), "provider.authenticate", attribute.String("pintu.provider", p.Name()))
+		identity, err := p.Authenticate(ctx, username, password)
+		EndSpan(span, err)
+		if err == ErrInvalidCredentials {
+			g.auditLockouts(r, p.Name(), username, attempt.fail())
+		} else if err != nil {
+			attempt.release(r.Context())
+		}
+		if err != nil {
+			g.loginError(w, r, p, username, err)
+			return
+		}
+		attempt.succeed(r.Context())
+		g.login(w, r, p, identity, redirect)
+	}
+}
+
+// beginLoginHandler sends the visitor to the portal of a RedirectProvider
+func (g *Guard) beginLoginHandler(p RedirectProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		callback := GetHostPath(r, p.Path()+"/"+callbackAction)
+		state := g.cookieFactory.csrfState(w, r, GetRedirect(r))
+		login, err := p.BeginLogin(r, callback, state)
+		if err != nil {
+			g.loginError(w, r, p, "", err)
+			return
+		}
+		http.Redirect(w, r, login, 302)
+	}
+}
+
+// completeLoginHandler serves the callback of a RedirectProvider
+func (g *Guard) completeLoginHandler(p RedirectProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			CustomError(w, r, err)
+			return
+		}
+		// a forged callback is turned down before its code is redeemed
+		redirect, ok := g.cookieFactory.validateCSRFState(r, r.Form.Get("state"))
+		if !ok {
+			g.loginError(w, r, p, "", ErrInvalidCSRF)
+			return
+		}
+		callback := GetHostPath(r, p.Path()+"/"+callbackAction)
+		ctx, span := StartSpan(r.Context(), "provider.complete_login", attribute.String("pintu.provider", p.Name()))
+		identity, _, err := p.CompleteLogin(r.WithContext(ctx), callback)
+		EndSpan(span, err)
+		if err != nil {
+			g.loginError(w, r, p, "", err)
+			return
+		}
+		if redirect == "" || strings.Contains(redirect, loginPromptPath) {
+			redirect = "/"
+		}
+		g.login(w, r, p, identity, redirect)
+	}
+}
+
+// auditLockouts logs and audits the keys a failed attempt locked out
+func (g *Guard) auditLockouts(r *http.Request, provider, username string, keys []lockedKey) {
+	for _, locked := range keys {
+		RequestLogger(r).Warn("login locked out", locked.kind, locked.value, "for", g.limiter.lockout.String())
+		g.audit.emit(r, AuditEvent{Type: AuditLockout, User: username, Provider: provider, Reason: locked.kind})
+	}
+}
+
+// login issues the cookie of an authenticated identity, pending its second
+// factor when one is owed
+func (g *Guard) login(w http.ResponseWriter, r *http.Request, p Authenticator, identity *Identity, redirect string) {
+	if identity.Provider == "" {
+		identity.Provider = p.Name()
+	}
+	page, err := g.mfa.next(r.Context(), identity.Email)
+	if err != nil {
+		CustomError(w, r, err)
+		return
+	}
+	session, err := NewSession(identity.Email, identity.Provider, g.cookieFactory.expiry)
+	if err == nil {
+		session.Pending = page != ""
+		err = g.sessions.Save(r.Context(), session)
+	}
+	if err != nil {
+		CustomError(w, r, err)
+		return
+	}
+	g.metrics.login(p.Name(), nil)
+	RequestLogger(r).Info("login succeeded", "user", identity.Email, "provider", identity.Provider)
+	g.audit.emit(r, AuditEvent{Type: AuditLoginSuccess, User: identity.Email, Provider: identity.Provider})
+	g.cookieFactory.ClearCSRFCookie(w, r)
+	g.cookieFactory.SetCookie(session.ID, w, r)
+	if page != "" {
+		mfaRedirect(w, r, page, redirect)
+		return
+	}
+	http.Redirect(w, r, redirect, 302)
+}
+
+// loginError answers a failed login, user is the attempted username when
+// the provider takes one
+func (g *Guard) loginError(w http.ResponseWriter, r *http.Request, p Authenticator, user string, err error) {
+	RequestLogger(r).Info("login failed", "provider", p.Name(), "err", err.Error())
+	g.audit.emit(r, AuditEvent{Type: AuditLoginFailure, User: user, Provider: p.Name(), Reason: err.Error()})
+	g.metrics.login(p.Name(), err)
+	if err == ErrAccessDenied {
+		Denied(w, r)
+		return
+	}
+	if err == ErrInvalidCSRF {
+		DefaultError(w, r, http.StatusForbidden, "Forbidden", err.Error())
+		return
+	}
+	if err == ErrTooManyAttempts {
+		DefaultError(w, r, http.StatusTooManyRequests, "Too Many Requests", err.Error())
+		return
+	}
+	CustomError(w, r, err)
+}
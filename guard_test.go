@@ -0,0 +1,82 @@
+package pintu
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sessionRequest is a GET of path carrying the cookie of a new session
+func sessionRequest(t *testing.T, g *Guard, path string, pending bool) (*http.Request, *Session) {
+	t.Helper()
+	session, err := NewSession("alice@example.com", "htpasswd", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Pending = pending
+	if err := g.sessions.Save(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	g.cookieFactory.SetCookie(session.ID, w, r)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r, session
+}
+
+func newTestGuard() *Guard {
+	g := NewGuard()
+	g.cookieFactory = NewCookieFactory("_pintu", "secret", 1)
+	return g
+}
+
+func TestPendingSessionDoesNotReachUpstream(t *testing.T) {
+	g := newTestGuard()
+	store, err := NewFileMFAStore(filepath.Join(t.TempDir(), "secrets.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.mfa = &mfa{store: store}
+	r, _ := sessionRequest(t, g, "/app", true)
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r, func(http.ResponseWriter, *http.Request) {
+		t.Fatal("a pending session reached the upstream")
+	})
+	if w.Code != http.StatusFound || w.Header().Get("Location") != mfaPath+"?rd=%2Fapp" {
+		t.Fatalf("pending session got %d to %q, want the code prompt", w.Code, w.Header().Get("Location"))
+	}
+
+	post := httptest.NewRequest("POST", "/app", nil)
+	for _, cookie := range r.Cookies() {
+		post.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, post, func(http.ResponseWriter, *http.Request) {
+		t.Fatal("a pending session posted to the upstream")
+	})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("pending session post got %d, want 401", w.Code)
+	}
+}
+
+func TestPendingSessionWithMFAOff(t *testing.T) {
+	g := newTestGuard()
+	r, session := sessionRequest(t, g, "/app", true)
+
+	reached := false
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r, func(http.ResponseWriter, *http.Request) { reached = true })
+	if !reached {
+		t.Fatalf("pending session got %d once mfa is off", w.Code)
+	}
+	stored, err := g.sessions.Load(context.Background(), session.ID)
+	if err != nil || stored.Pending {
+		t.Fatalf("stored session = %+v, %v, want no longer pending", stored, err)
+	}
+}
@@ -0,0 +1,183 @@
+package pintu
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+const (
+	pingPath    = "/ping"
+	readyPath   = "/ready"
+	versionPath = "/version"
+
+	healthTimeout = 2 * time.Second
+	// readyCacheTTL spares the backends a check per probe
+	readyCacheTTL = 5 * time.Second
+	sessionsCheck = "session_store"
+
+	defaultHealthPrefix = "/_pintu"
+)
+
+type (
+	// HealthChecker is optionally implemented by the providers and session
+	// stores relying on a backend, /ready fails while the backend is down
+	HealthChecker interface {
+		CheckHealth(ctx context.Context) error
+	}
+
+	// healthCheck names the checker in the /ready report
+	healthCheck struct {
+		name    string
+		checker HealthChecker
+	}
+
+	// Readiness is the /ready report, the checks map to ok or failing and
+	// are only listed on the admin listener, the errors are only logged
+	Readiness struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks,omitempty"`
+	}
+
+	// BuildInfo is the /version report
+	BuildInfo struct {
+		Version   string `json:"version"`
+		Revision  string `json:"revision,omitempty"`
+		GoVersion string `json:"go_version"`
+	}
+)
+
+// healthChecks lists the checkers of the providers, the unwrapped second
+// generation ones included
+func healthChecks(providers []Provider) []healthCheck {
+	var checks []healthCheck
+	for _, p := range providers {
+		var candidate interface{} = p
+		if ap, ok := p.(*authProvider); ok {
+			candidate = ap.Authenticator
+		}
+		if c, ok := candidate.(HealthChecker); ok {
+			checks = append(checks, healthCheck{name: p.Name(), checker: c})
+		}
+	}
+	return checks
+}
+
+// health serves the probes ahead of the access log and the Guard, they
+// neither require a login nor flood the logs, /ready only tells the status
+func (p *Pintu) health(prefix string) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		switch r.URL.Path {
+		case prefix + pingPath:
+			p.servePing(w, r)
+		case prefix + readyPath:
+			p.serveReady(w, r, false)
+		case prefix + versionPath:
+			p.serveVersion(w, r)
+		default:
+			next(w, r)
+		}
+	}
+}
+
+// servePing is the liveness probe
+func (p *Pintu) servePing(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("pong\n"))
+}
+
+// serveReady answers 503 while draining or when a backend check fails, the
+// checks are listed when detailed
+func (p *Pintu) serveReady(w http.ResponseWriter, r *http.Request, detailed bool) {
+	readiness := p.cachedReadiness(r.Context())
+	if !detailed {
+		readiness = Readiness{Status: readiness.Status}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if readiness.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(readiness)
+}
+
+// cachedReadiness runs the checks at most once every readyCacheTTL, the
+// concurrent probes wait for the running one
+func (p *Pintu) cachedReadiness(ctx context.Context) Readiness {
+	if !p.Ready() {
+		return Readiness{Status: "draining"}
+	}
+	p.readyMu.Lock()
+	defer p.readyMu.Unlock()
+	if time.Since(p.readyAt) < readyCacheTTL {
+		return p.readyLast
+	}
+	p.readyLast = p.Readiness(ctx)
+	p.readyAt = time.Now()
+	return p.readyLast
+}
+
+func (p *Pintu) serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.BuildInfo())
+}
+
+// Readiness runs the provider and session store checks concurrently, each
+// within healthTimeout
+func (p *Pintu) Readiness(ctx context.Context) Readiness {
+	if !p.Ready() {
+		return Readiness{Status: "draining"}
+	}
+	checks := append([]healthCheck{}, p.runtime().checks...)
+	if c, ok := p.sessions.(HealthChecker); ok {
+		checks = append(checks, healthCheck{name: sessionsCheck, checker: c})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	readiness := Readiness{Status: "ok", Checks: make(map[string]string, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check healthCheck) {
+			defer wg.Done()
+			result := "ok"
+			if err := check.checker.CheckHealth(ctx); err != nil {
+				Logger().Warn("health check failed", "check", check.name, "err", err.Error())
+				result = "failing"
+			}
+			mu.Lock()
+			readiness.Checks[check.name] = result
+			if result != "ok" {
+				readiness.Status = "failing"
+			}
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+	return readiness
+}
+
+// BuildInfo reports Options.Version, the module version when empty, and
+// the vcs revision stamped by the go toolchain
+func (p *Pintu) BuildInfo() BuildInfo {
+	info := BuildInfo{Version: p.options.Version}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = build.GoVersion
+	if info.Version == "" {
+		info.Version = build.Main.Version
+	}
+	for _, setting := range build.Settings {
+		if setting.Key == "vcs.revision" {
+			info.Revision = setting.Value
+		}
+	}
+	return info
+}
@@ -0,0 +1,53 @@
+package pintu
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type countingCheck struct {
+	calls atomic.Int32
+}
+
+func (c *countingCheck) CheckHealth(ctx context.Context) error {
+	c.calls.Add(1)
+	return errors.New("ldap down")
+}
+
+func TestReady(t *testing.T) {
+	check := &countingCheck{}
+	p := &Pintu{}
+	p.current.Store(&runtime{checks: []healthCheck{{name: "LDAP", checker: check}}})
+	health := p.health(defaultHealthPrefix)
+	admin := p.adminHandler()
+
+	tests := []struct {
+		handler http.Handler
+		path    string
+		code    int
+		body    string
+	}{
+		{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			health(w, r, http.NotFound)
+		}), "/_pintu/ready", http.StatusServiceUnavailable, `{"status":"failing"}`},
+		{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			health(w, r, http.NotFound)
+		}), "/ready", http.StatusNotFound, "404 page not found"},
+		{admin, "/ready", http.StatusServiceUnavailable, `{"status":"failing","checks":{"LDAP":"failing"}}`},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		test.handler.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if body := strings.TrimSpace(w.Body.String()); w.Code != test.code || body != test.body {
+			t.Errorf("GET %s = %d %s, want %d %s", test.path, w.Code, body, test.code, test.body)
+		}
+	}
+	if calls := check.calls.Load(); calls != 1 {
+		t.Fatalf("the probes ran the check %d times, want once", calls)
+	}
+}
@@ -3,7 +3,6 @@ package pintu
 import (
 	"errors"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 
@@ -12,7 +11,12 @@ import (
 
 var ErrAPIError = errors.New("api request returned non 200 status code")
 
+// IsSecured tells whether the client reached pintu, or the proxy in front
+// of it, over https
 func IsSecured(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
 	if scheme := req.Header.Get("X-Forwarded-Proto"); scheme == "https" {
 		return true
 	}
@@ -26,6 +30,9 @@ func GetDomain(req *http.Request) string {
 // GetHostURL retrieves host url from http request
 func GetHostURL(req *http.Request) string {
 	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
 	if req.Header.Get("X-Forwarded-Proto") != "" && len(req.Header["X-Forwarded-Proto"]) > 0 {
 		proto = req.Header["X-Forwarded-Proto"][0]
 	}
@@ -48,13 +55,13 @@ func GetRedirect(r *http.Request) string {
 	return redirect
 }
 
-// GetRemoteIP retrieves visitor ip address
+// GetRemoteIP returns the visitor ip, taken from the forwarded headers only
+// when the peer is one of the trusted_proxies
 func GetRemoteIP(req *http.Request) string {
-	remoteIP := req.Header.Get("X-Real-IP")
-	if remoteIP == "" {
-		remoteIP = req.RemoteAddr
+	if info, ok := req.Context().Value(requestInfoKey{}).(*requestInfo); ok && info.ip != "" {
+		return info.ip
 	}
-	return strings.Split(remoteIP, ":")[0]
+	return peerIP(req)
 }
 
 // // APIRequest processes http requests and serializes response to json
@@ -71,7 +78,8 @@ func APIRequest(r *http.Request) (*simplejson.Json, error) {
 		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		log.Printf("got response code %d - %s", resp.StatusCode, body)
+		// the body is left out, it may echo the request credentials
+		Logger().Warn("api request failed", "url", r.URL.Scheme+"://"+r.URL.Host+r.URL.Path, "status", resp.StatusCode)
 		return nil, ErrAPIError
 	}
 
@@ -0,0 +1,249 @@
+package pintu
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+type (
+	// Attempts counts the failed logins of a username or a client ip, the
+	// attempts still being checked included
+	Attempts struct {
+		Failures int       `json:"failures"`
+		Last     time.Time `json:"last"`
+	}
+
+	// AttemptStore is optionally implemented by the session stores so the
+	// failed login counters are shared between the replicas, pintu keeps
+	// them in memory otherwise
+	AttemptStore interface {
+		// AddAttempt counts an attempt of key ahead of its check and returns
+		// the attempts before it, zero for unknown and expired keys, the
+		// count and the read are atomic so parallel attempts see each other,
+		// the key expires ttl after its last attempt
+		AddAttempt(ctx context.Context, key string, ttl time.Duration) (Attempts, error)
+		// RemoveAttempt takes back an attempt which did not fail
+		RemoveAttempt(ctx context.Context, key string) error
+		ResetAttempts(ctx context.Context, key string) error
+	}
+
+	// loginLimiter slows the password guessing of the credential providers,
+	// every failure of a username doubles its delay and reaching the
+	// threshold of a username or a client ip locks it out
+	loginLimiter struct {
+		store   AttemptStore
+		maxUser int
+		maxIP   int
+		backoff time.Duration
+		lockout time.Duration
+	}
+
+	// attempt is a login attempt reserved with the limiter, it stays
+	// counted as a failure unless it succeeds or is released
+	attempt struct {
+		limiter *loginLimiter
+		keys    []attemptKey
+	}
+
+	// attemptKey is a counter of an attempt, failures includes the attempt
+	attemptKey struct {
+		lockedKey
+		key      string
+		max      int
+		failures int
+	}
+
+	// lockedKey is a username or client ip locked out by a failure
+	lockedKey struct {
+		kind, value string
+	}
+
+	attemptRecord struct {
+		Attempts
+		expires time.Time
+	}
+)
+
+const (
+	lockUser = "username"
+	lockIP   = "client_ip"
+)
+
+func newLoginLimiter(store AttemptStore, s *Settings) *loginLimiter {
+	return &loginLimiter{
+		store:   store,
+		maxUser: int(s.LoginMaxFailures),
+		maxIP:   int(s.LoginMaxFailuresIP),
+		backoff: time.Duration(s.LoginBackoff) * time.Second,
+		lockout: time.Duration(s.LoginLockout) * time.Second,
+	}
+}
+
+func userKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// ipKey counts the ipv6 clients by /64, the block handed to a single
+// subscriber
+func ipKey(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		mask := net.CIDRMask(64, 128)
+		return "ip:" + (&net.IPNet{IP: parsed.Mask(mask), Mask: mask}).String()
+	}
+	return "ip:" + ip
+}
+
+// reserve counts the attempt of username from ip ahead of the password
+// check and returns how long to wait when it is refused, the refused
+// attempts are taken back and restart the wait. An unreachable store lets
+// the attempt through, a nil limiter lets everything through
+func (l *loginLimiter) reserve(ctx context.Context, ip, username string) (*attempt, time.Duration) {
+	if l == nil {
+		return nil, 0
+	}
+	a := &attempt{limiter: l}
+	now := time.Now()
+	var wait time.Duration
+	add := func(kind, value, key string, max int, backoff bool) {
+		previous, err := l.store.AddAttempt(ctx, key, l.lockout)
+		if err != nil {
+			Logger().Error("login attempts update failed", "err", err.Error())
+			return
+		}
+		a.keys = append(a.keys, attemptKey{
+			lockedKey: lockedKey{kind: kind, value: value},
+			key:       key,
+			max:       max,
+			failures:  previous.Failures + 1,
+		})
+		if previous.Failures == 0 {
+			return
+		}
+		if w := previous.Last.Add(l.delay(previous.Failures, max, backoff)).Sub(now); w > wait {
+			wait = w
+		}
+	}
+	if l.maxUser > 0 && username != "" {
+		add(lockUser, username, userKey(username), l.maxUser, true)
+	}
+	if l.maxIP > 0 {
+		add(lockIP, ip, ipKey(ip), l.maxIP, false)
+	}
+	if wait > 0 {
+		a.release(ctx)
+		return nil, wait
+	}
+	return a, 0
+}
+
+// delay is the lockout past max failures, before it the backoff doubling
+// from the second failure, the client ips only get the lockout as they may
+// be shared behind a nat
+func (l *loginLimiter) delay(failures, max int, backoff bool) time.Duration {
+	if failures >= max {
+		return l.lockout
+	}
+	if !backoff || l.backoff <= 0 || failures < 2 {
+		return 0
+	}
+	delay := l.backoff
+	for i := 2; i < failures && delay < l.lockout; i++ {
+		delay *= 2
+	}
+	if delay > l.lockout {
+		return l.lockout
+	}
+	return delay
+}
+
+// fail keeps the attempt counted for a rejected password, it returns the
+// keys it locked out
+func (a *attempt) fail() []lockedKey {
+	if a == nil {
+		return nil
+	}
+	var locked []lockedKey
+	for _, k := range a.keys {
+		if k.failures == k.max {
+			locked = append(locked, k.lockedKey)
+		}
+	}
+	return locked
+}
+
+// succeed clears the failures of the username and takes the attempt back
+// from the ip, a known account does not reset the guessing of others
+func (a *attempt) succeed(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	for _, k := range a.keys {
+		var err error
+		if k.kind == lockUser {
+			err = a.limiter.store.ResetAttempts(ctx, k.key)
+		} else {
+			err = a.limiter.store.RemoveAttempt(ctx, k.key)
+		}
+		if err != nil {
+			Logger().Error("login attempts reset failed", "err", err.Error())
+		}
+	}
+}
+
+// release takes back an attempt which is no password failure, refused or
+// stopped by a backend error
+func (a *attempt) release(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	for _, k := range a.keys {
+		if err := a.limiter.store.RemoveAttempt(ctx, k.key); err != nil {
+			Logger().Error("login attempts update failed", "err", err.Error())
+		}
+	}
+}
+
+// AddAttempt counts an attempt of key, returns the attempts before it and
+// drops the expired keys
+func (m *MemoryStore) AddAttempt(ctx context.Context, key string, ttl time.Duration) (Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for k, record := range m.attempts {
+		if !now.Before(record.expires) {
+			delete(m.attempts, k)
+		}
+	}
+	record := m.attempts[key]
+	previous := record.Attempts
+	record.Failures++
+	record.Last = now
+	record.expires = now.Add(ttl)
+	m.attempts[key] = record
+	return previous, nil
+}
+
+func (m *MemoryStore) RemoveAttempt(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.attempts[key]
+	if !ok {
+		return nil
+	}
+	record.Failures--
+	if record.Failures <= 0 {
+		delete(m.attempts, key)
+		return nil
+	}
+	m.attempts[key] = record
+	return nil
+}
+
+func (m *MemoryStore) ResetAttempts(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	return nil
+}
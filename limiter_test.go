@@ -0,0 +1,112 @@
+package pintu
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(maxUser, maxIP int) *loginLimiter {
+	return &loginLimiter{
+		store:   NewMemoryStore(),
+		maxUser: maxUser,
+		maxIP:   maxIP,
+		lockout: time.Minute,
+	}
+}
+
+func TestLockoutAtThreshold(t *testing.T) {
+	l := newTestLimiter(3, 0)
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		a, wait := l.reserve(ctx, "192.0.2.1", "alice")
+		if wait != 0 {
+			t.Fatalf("attempt %d refused for %v", i, wait)
+		}
+		locked := a.fail()
+		if (i == 3) != (len(locked) == 1) {
+			t.Fatalf("attempt %d locked %v", i, locked)
+		}
+	}
+	if _, wait := l.reserve(ctx, "192.0.2.1", "Alice"); wait <= 0 || wait > time.Minute {
+		t.Fatalf("attempt past the threshold waits %v, want the lockout", wait)
+	}
+	if _, wait := l.reserve(ctx, "192.0.2.1", "bob"); wait != 0 {
+		t.Fatalf("another username waits %v", wait)
+	}
+}
+
+func TestSuccessClearsUsername(t *testing.T) {
+	l := newTestLimiter(2, 0)
+	ctx := context.Background()
+	a, _ := l.reserve(ctx, "192.0.2.1", "alice")
+	a.fail()
+	a, _ = l.reserve(ctx, "192.0.2.1", "alice")
+	a.succeed(ctx)
+	a, wait := l.reserve(ctx, "192.0.2.1", "alice")
+	if wait != 0 || len(a.fail()) != 0 {
+		t.Fatal("a success did not clear the failures of the username")
+	}
+}
+
+func TestParallelReservations(t *testing.T) {
+	const max = 5
+	l := newTestLimiter(max, 0)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	admitted, locked := 0, 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a, wait := l.reserve(ctx, "192.0.2.1", "alice")
+			if wait > 0 {
+				return
+			}
+			keys := a.fail()
+			mu.Lock()
+			admitted++
+			locked += len(keys)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if admitted > max || locked != 1 {
+		t.Fatalf("%d parallel attempts admitted and %d lockouts, want at most %d and 1", admitted, locked, max)
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	l := newTestLimiter(0, 2)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		a, _ := l.reserve(ctx, "2001:db8:1:2:3::1", []string{"alice", "bob"}[i])
+		a.fail()
+	}
+	if _, wait := l.reserve(ctx, "2001:db8:1:2:ffff::9", "other"); wait == 0 {
+		t.Fatal("another address of the /64 is not locked out")
+	}
+	if _, wait := l.reserve(ctx, "2001:db8:1:3::1", "other"); wait != 0 {
+		t.Fatal("the next /64 is locked out")
+	}
+}
+
+func TestIPKey(t *testing.T) {
+	tests := []struct {
+		ip, key string
+	}{
+		{"192.0.2.1", "ip:192.0.2.1"},
+		{"2001:db8:1:2:3:4:5:6", "ip:2001:db8:1:2::/64"},
+		{"2001:db8:1:2::", "ip:2001:db8:1:2::/64"},
+		{"::ffff:192.0.2.1", "ip:::ffff:192.0.2.1"},
+		{"", "ip:"},
+	}
+	for _, test := range tests {
+		if key := ipKey(test.ip); key != test.key {
+			t.Errorf("ipKey(%q) = %q, want %q", test.ip, key, test.key)
+		}
+	}
+}
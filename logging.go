@@ -0,0 +1,124 @@
+package pintu
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type (
+	// requestInfo follows a request through the middlewares, the Guard
+	// fills the user in
+	requestInfo struct {
+		id   string
+		ip   string
+		user atomic.Value
+	}
+
+	requestInfoKey struct{}
+)
+
+var (
+	logger atomic.Value
+
+	errLogFormat = errors.New("supported formats are logfmt and json")
+	errLogLevel  = errors.New("supported levels are debug, info, warn and error")
+)
+
+func init() {
+	logger.Store(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+}
+
+// Logger returns the logger of pintu and its providers
+func Logger() *slog.Logger {
+	return logger.Load().(*slog.Logger)
+}
+
+// newLogger builds the logger of the log_format and log_level settings
+func newLogger(format, level string) (*slog.Logger, error) {
+	var errs ValidationErrors
+	format = strings.ToLower(format)
+	if format != "logfmt" && format != "json" {
+		errs.Add(InvalidParam(optionLogFormat, errLogFormat))
+	}
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		errs.Add(InvalidParam(optionLogLevel, errLogLevel))
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return slog.New(handler), nil
+}
+
+// RequestLogger returns the logger carrying the request id of r
+func RequestLogger(r *http.Request) *slog.Logger {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return Logger().With("request_id", info.id)
+	}
+	return Logger()
+}
+
+// setRequestUser records the authenticated user for the access log
+func setRequestUser(r *http.Request, user string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.user.Store(user)
+	}
+}
+
+// accessLog assigns the request id, kept from X-Request-ID when the client
+// or the proxy in front sends one, forwards it upstream and logs every
+// request once served, the query string is left out as it may carry oauth
+// codes or tokens, the client ip is resolved once for the whole request
+func accessLog(proxies *trustedProxies) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		info := &requestInfo{id: id, ip: proxies.clientIP(r)}
+		next(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		status, size := http.StatusOK, 0
+		if res, ok := w.(negroni.ResponseWriter); ok {
+			status, size = res.Status(), res.Size()
+		}
+		user, _ := info.user.Load().(string)
+		Logger().Info("request",
+			"request_id", id,
+			"method", r.Method,
+			"host", r.Host,
+			"path", r.URL.Path,
+			"status", status,
+			"size", size,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_ip", info.ip,
+			"user", user,
+		)
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,21 @@
+package pintu
+
+import "errors"
+
+const metricsPath = "/metrics"
+
+// login and cookie outcomes
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+	resultError   = "error"
+	resultLocked  = "locked"
+
+	cookieValid   = "valid"
+	cookieMissing = "missing"
+	cookieInvalid = "invalid"
+	cookieUnknown = "unknown_session"
+	cookieError   = "error"
+)
+
+var errNoMetrics = errors.New("pintu was built with the nometrics tag")
@@ -0,0 +1,30 @@
+//go:build nometrics
+// +build nometrics
+
+package pintu
+
+import "net/http"
+
+// The nometrics tag leaves prometheus out, the metrics_http option is then
+// rejected and the counters are no-ops
+
+const metricsBuilt = false
+
+type metrics struct{}
+
+func newMetrics(sessions func() SessionStore) *metrics {
+	return nil
+}
+
+// MetricsHandler answers 404, the metrics are not built in
+func (p *Pintu) MetricsHandler() http.Handler {
+	return http.NotFoundHandler()
+}
+
+func (m *metrics) login(provider string, err error) {}
+
+func (m *metrics) cookie(result string) {}
+
+func (m *metrics) proxy(routes Upstreams) http.Handler {
+	return routes
+}
@@ -0,0 +1,160 @@
+//go:build !nometrics
+// +build !nometrics
+
+package pintu
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsBuilt tells whether the prometheus metrics are compiled in
+const metricsBuilt = true
+
+type (
+	// metrics are the prometheus collectors of a pintu, they outlive the
+	// reloads
+	metrics struct {
+		registry         *prometheus.Registry
+		logins           *prometheus.CounterVec
+		cookies          *prometheus.CounterVec
+		backendErrors    *prometheus.CounterVec
+		upstreamDuration *prometheus.HistogramVec
+	}
+
+	// statusWriter records the status code written by the proxy
+	statusWriter struct {
+		http.ResponseWriter
+		status int
+	}
+)
+
+func newMetrics(sessions func() SessionStore) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pintu_login_attempts_total",
+			Help: "Login attempts by provider and result, success, failure or error.",
+		}, []string{"provider", "result"}),
+		cookies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pintu_cookie_validations_total",
+			Help: "Session cookie validations by result.",
+		}, []string{"result"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pintu_provider_backend_errors_total",
+			Help: "Failures of the provider backends, ie ldap server or oauth token exchange.",
+		}, []string{"provider"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pintu_upstream_request_duration_seconds",
+			Help:    "Upstream requests latency by upstream and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"upstream", "code"}),
+	}
+	activeSessions := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pintu_active_sessions",
+		Help: "Sessions which are neither expired nor revoked.",
+	}, func() float64 {
+		store := sessions()
+		if store == nil {
+			return 0
+		}
+		count, err := CountSessions(context.Background(), store)
+		if err != nil {
+			return -1
+		}
+		return float64(count)
+	})
+	m.registry.MustRegister(
+		m.logins,
+		m.cookies,
+		m.backendErrors,
+		m.upstreamDuration,
+		activeSessions,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// MetricsHandler serves the prometheus metrics, Start serves it on the
+// metrics_http listener
+func (p *Pintu) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.HandlerFor(p.metrics.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// login counts a login attempt, the provider backend failures apart from
+// the rejected credentials
+func (m *metrics) login(provider string, err error) {
+	if m == nil {
+		return
+	}
+	switch {
+	case err == nil:
+		m.logins.WithLabelValues(provider, resultSuccess).Inc()
+	case err == ErrTooManyAttempts:
+		m.logins.WithLabelValues(provider, resultLocked).Inc()
+	case errors.Is(err, ErrAuthServerDown):
+		m.logins.WithLabelValues(provider, resultError).Inc()
+		m.backendErrors.WithLabelValues(provider).Inc()
+	default:
+		m.logins.WithLabelValues(provider, resultFailure).Inc()
+	}
+}
+
+func (m *metrics) cookie(result string) {
+	if m == nil {
+		return
+	}
+	m.cookies.WithLabelValues(result).Inc()
+}
+
+// proxy times the upstream requests of routes
+func (m *metrics) proxy(routes Upstreams) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := routes.Match(r)
+		if u == nil || m == nil {
+			routes.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		u.handler.ServeHTTP(sw, r)
+		m.upstreamDuration.WithLabelValues(u.Host+u.Path, strconv.Itoa(sw.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the websocket connections over to the proxy
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,438 @@
+package pintu
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	mfaPath       = "/auth/mfa"
+	mfaEnrollPath = "/auth/mfa/enroll"
+
+	mfaOptional = "optional"
+	mfaRequired = "required"
+)
+
+type (
+	// MFASecret is the TOTP enrollment of a user
+	MFASecret struct {
+		Secret    string    `json:"secret"`
+		CreatedAt time.Time `json:"created_at"`
+		// LastStep is the time step of the last accepted code, a code is
+		// only accepted once
+		LastStep int64 `json:"last_step"`
+	}
+
+	// MFAStore keeps the TOTP secrets of the enrolled users by email
+	MFAStore interface {
+		// LoadSecret returns ErrMFANotEnrolled for the users without secret
+		LoadSecret(ctx context.Context, email string) (*MFASecret, error)
+		SaveSecret(ctx context.Context, email string, secret *MFASecret) error
+		DeleteSecret(ctx context.Context, email string) error
+		// UseStep moves the LastStep of email to step in one atomic compare
+		// and set, it returns ErrMFACodeUsed unless step is after LastStep so
+		// parallel posts of a code accept it once
+		UseStep(ctx context.Context, email string, step int64) error
+	}
+
+	// FileMFAStore keeps the secrets in a json file only readable by pintu,
+	// it is read again on every lookup so the replicas may share it
+	FileMFAStore struct {
+		mu   sync.Mutex
+		path string
+	}
+
+	// mfa is the second factor setup of a configuration, required asks
+	// every user to enroll on login, the enrolled ones always give a code
+	mfa struct {
+		store    MFAStore
+		required bool
+		issuer   string
+	}
+
+	// mfaPage renders the code prompt and, with QRCode, the enrollment
+	mfaPage struct {
+		Action     string
+		Redirect   string
+		CSRF       string
+		Error      string
+		QRCode     template.URL
+		Secret     string
+		Enrollment string
+	}
+)
+
+var (
+	ErrMFANotEnrolled = errors.New("second factor not enrolled")
+	ErrMFARequired    = errors.New("Second factor required")
+	ErrMFACodeUsed    = errors.New("second factor code already used")
+
+	errMFAMode        = errors.New("supported modes are optional and required")
+	errMFARequest     = errors.New("required can not apply to the request providers, their identities have no session, use rules with mfa instead")
+	errMFAEnrollment  = errors.New("The enrollment expired, please try again")
+	errMFAInvalidCode = errors.New("Invalid code")
+)
+
+// NewFileMFAStore checks the secrets file, it is created on the first
+// enrollment
+func NewFileMFAStore(path string) (*FileMFAStore, error) {
+	s := &FileMFAStore{path: path}
+	if _, err := s.read(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileMFAStore) read() (map[string]*MFASecret, error) {
+	secrets := make(map[string]*MFASecret)
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// write replaces the file at once so a crash leaves either version
+func (s *FileMFAStore) write(secrets map[string]*MFASecret) error {
+	data, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileMFAStore) LoadSecret(ctx context.Context, email string) (*MFASecret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	secret, ok := secrets[strings.ToLower(email)]
+	if !ok {
+		return nil, ErrMFANotEnrolled
+	}
+	return secret, nil
+}
+
+func (s *FileMFAStore) SaveSecret(ctx context.Context, email string, secret *MFASecret) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+	secrets[strings.ToLower(email)] = secret
+	return s.write(secrets)
+}
+
+func (s *FileMFAStore) UseStep(ctx context.Context, email string, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+	secret, ok := secrets[strings.ToLower(email)]
+	if !ok {
+		return ErrMFANotEnrolled
+	}
+	if step <= secret.LastStep {
+		return ErrMFACodeUsed
+	}
+	secret.LastStep = step
+	return s.write(secrets)
+}
+
+func (s *FileMFAStore) DeleteSecret(ctx context.Context, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+	delete(secrets, strings.ToLower(email))
+	return s.write(secrets)
+}
+
+// newMFA sets the second factor up, store replaces the mfa_secrets file,
+// it is off without any store unless a rule or the mfa option needs it
+func newMFA(store MFAStore, s *Settings) (*mfa, error) {
+	var errs ValidationErrors
+	if s.MFA != mfaOptional && s.MFA != mfaRequired {
+		errs.Add(InvalidParam(optionMFA, errMFAMode))
+	}
+	if store == nil && s.MFASecrets != "" {
+		fileStore, err := NewFileMFAStore(s.MFASecrets)
+		if err != nil {
+			errs.Add(InvalidParam(optionMFASecrets, err))
+		} else {
+			store = fileStore
+		}
+	}
+	needed := s.MFA == mfaRequired || s.Rules.mfa()
+	for _, u := range s.Upstreams {
+		needed = needed || u.Rules.mfa()
+	}
+	if store == nil && needed && len(errs) == 0 {
+		errs.Add(MissingParam(optionMFASecrets))
+	}
+	if err := errs.Err(); err != nil || store == nil {
+		return nil, err
+	}
+	return &mfa{store: store, required: s.MFA == mfaRequired, issuer: s.MFAIssuer}, nil
+}
+
+// checkProviders rejects the required mode along the request providers, ie
+// mtls, which could never be asked the code
+func (m *mfa) checkProviders(providers []Provider) error {
+	if m == nil || !m.required {
+		return nil
+	}
+	for _, p := range providers {
+		if p.Type() == "request" {
+			return InvalidParam(optionMFA, fmt.Errorf("%s: %w", p.Name(), errMFARequest))
+		}
+	}
+	return nil
+}
+
+// next returns the page of the second factor owed after the login of
+// email, empty when there is none
+func (m *mfa) next(ctx context.Context, email string) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+	_, err := m.store.LoadSecret(ctx, email)
+	switch {
+	case err == nil:
+		return mfaPath, nil
+	case err != ErrMFANotEnrolled:
+		return "", err
+	case m.required:
+		return mfaEnrollPath, nil
+	}
+	return "", nil
+}
+
+// mfaRedirect sends the visitor to the second factor page, back to
+// redirect once done
+func mfaRedirect(w http.ResponseWriter, r *http.Request, page, redirect string) {
+	http.Redirect(w, r, page+"?rd="+url.QueryEscape(redirect), 302)
+}
+
+// requireMFA asks the second factor of the session before going on, the
+// streams and form posts can not follow the prompt
+func (g *Guard) requireMFA(w http.ResponseWriter, r *http.Request) {
+	if g.mfa == nil {
+		Denied(w, r)
+		return
+	}
+	if r.Method != "GET" || isStream(r) {
+		DefaultError(w, r, http.StatusUnauthorized, "Unauthorized", ErrMFARequired.Error())
+		return
+	}
+	mfaRedirect(w, r, mfaPath, r.URL.RequestURI())
+}
+
+// serveMFA serves the code prompt and the enrollment to the logged in
+// visitors, pending their second factor or stepping up
+func (g *Guard) serveMFA(w http.ResponseWriter, r *http.Request, session *Session) {
+	if g.mfa == nil {
+		NotFound(w, r)
+		return
+	}
+	switch r.URL.Path {
+	case mfaPath:
+		g.mfaPrompt(w, r, session)
+	case mfaEnrollPath:
+		g.mfaEnroll(w, r, session)
+	default:
+		NotFound(w, r)
+	}
+}
+
+func (g *Guard) mfaPrompt(w http.ResponseWriter, r *http.Request, session *Session) {
+	secret, err := g.mfa.store.LoadSecret(r.Context(), session.Email)
+	if err == ErrMFANotEnrolled {
+		mfaRedirect(w, r, mfaEnrollPath, GetRedirect(r))
+		return
+	}
+	if err != nil {
+		CustomError(w, r, err)
+		return
+	}
+	page := &mfaPage{Action: mfaPath, Redirect: GetRedirect(r)}
+	if r.Method != "POST" {
+		g.renderMFA(w, r, http.StatusOK, page)
+		return
+	}
+	accept := func(step int64) error {
+		return g.mfa.store.UseStep(r.Context(), session.Email, step)
+	}
+	if !g.verifyCode(w, r, session, page, secret, accept) {
+		return
+	}
+	g.completeMFA(w, r, session, AuditMFASuccess)
+}
+
+// mfaEnroll shows a new secret as a qr code and saves it once a first code
+// is confirmed, the enrolled users give a code before enrolling again
+func (g *Guard) mfaEnroll(w http.ResponseWriter, r *http.Request, session *Session) {
+	_, err := g.mfa.store.LoadSecret(r.Context(), session.Email)
+	if err == nil && !session.MFA {
+		mfaRedirect(w, r, mfaPath, GetRedirect(r))
+		return
+	}
+	if err != nil && err != ErrMFANotEnrolled {
+		CustomError(w, r, err)
+		return
+	}
+
+	page := &mfaPage{Action: mfaEnrollPath, Redirect: GetRedirect(r)}
+	secret := &MFASecret{CreatedAt: time.Now()}
+	if r.Method == "POST" {
+		var ok bool
+		if secret.Secret, ok = g.openEnrollment(session, r.PostFormValue("enrollment")); !ok {
+			DefaultError(w, r, http.StatusForbidden, "Forbidden", errMFAEnrollment.Error())
+			return
+		}
+	} else if secret.Secret, err = newTOTPSecret(); err != nil {
+		CustomError(w, r, err)
+		return
+	}
+	png, err := qrcode.Encode(totpURI(g.mfa.issuer, session.Email, secret.Secret), qrcode.Medium, 256)
+	if err != nil {
+		CustomError(w, r, err)
+		return
+	}
+	page.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	page.Secret = secret.Secret
+	page.Enrollment = g.sealEnrollment(session, secret.Secret)
+	if r.Method != "POST" {
+		g.renderMFA(w, r, http.StatusOK, page)
+		return
+	}
+	accept := func(step int64) error {
+		secret.LastStep = step
+		return g.mfa.store.SaveSecret(r.Context(), session.Email, secret)
+	}
+	if !g.verifyCode(w, r, session, page, secret, accept) {
+		return
+	}
+	RequestLogger(r).Info("second factor enrolled", "user", session.Email)
+	g.completeMFA(w, r, session, AuditMFAEnrolled)
+}
+
+// verifyCode checks the posted code against secret and has accept record
+// its step, a step accept finds used is a failure like a wrong code and the
+// failures count toward the lockout of the user
+func (g *Guard) verifyCode(w http.ResponseWriter, r *http.Request, session *Session, page *mfaPage, secret *MFASecret, accept func(step int64) error) bool {
+	if !g.cookieFactory.ValidateCSRF(r) {
+		g.audit.emit(r, AuditEvent{Type: AuditMFAFailure, User: session.Email, Provider: session.Provider, Reason: ErrInvalidCSRF.Error()})
+		DefaultError(w, r, http.StatusForbidden, "Forbidden", ErrInvalidCSRF.Error())
+		return false
+	}
+	attempt, wait := g.limiter.reserve(r.Context(), GetRemoteIP(r), session.Email)
+	if wait > 0 {
+		g.audit.emit(r, AuditEvent{Type: AuditMFAFailure, User: session.Email, Provider: session.Provider, Reason: ErrTooManyAttempts.Error()})
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+		DefaultError(w, r, http.StatusTooManyRequests, "Too Many Requests", ErrTooManyAttempts.Error())
+		return false
+	}
+	step, ok := verifyTOTP(secret.Secret, r.PostFormValue("code"), secret.LastStep, time.Now())
+	if ok {
+		if err := accept(step); err == ErrMFACodeUsed {
+			ok = false
+		} else if err != nil {
+			attempt.release(r.Context())
+			CustomError(w, r, err)
+			return false
+		}
+	}
+	if !ok {
+		RequestLogger(r).Info("second factor failed", "user", session.Email)
+		g.audit.emit(r, AuditEvent{Type: AuditMFAFailure, User: session.Email, Provider: session.Provider, Reason: errMFAInvalidCode.Error()})
+		g.auditLockouts(r, session.Provider, session.Email, attempt.fail())
+		page.Error = errMFAInvalidCode.Error()
+		g.renderMFA(w, r, http.StatusUnauthorized, page)
+		return false
+	}
+	attempt.succeed(r.Context())
+	return true
+}
+
+// completeMFA replaces the session by one carrying the second factor, a
+// new id keeps a planted pre-mfa cookie from gaining it
+func (g *Guard) completeMFA(w http.ResponseWriter, r *http.Request, session *Session, event string) {
+	upgraded, err := NewSession(session.Email, session.Provider, g.cookieFactory.expiry)
+	if err == nil {
+		upgraded.MFA = true
+		err = g.sessions.Save(r.Context(), upgraded)
+	}
+	if err != nil {
+		CustomError(w, r, err)
+		return
+	}
+	if err := g.sessions.Delete(r.Context(), session.ID); err != nil {
+		RequestLogger(r).Error("pre-mfa session not deleted", "err", err.Error())
+	}
+	g.audit.emit(r, AuditEvent{Type: event, User: session.Email, Provider: session.Provider})
+	g.cookieFactory.ClearCSRFCookie(w, r)
+	g.cookieFactory.SetCookie(upgraded.ID, w, r)
+	http.Redirect(w, r, GetRedirect(r), 302)
+}
+
+func (g *Guard) renderMFA(w http.ResponseWriter, r *http.Request, status int, page *mfaPage) {
+	r = g.cookieFactory.withCSRFToken(w, r)
+	page.CSRF = CSRFToken(r)
+	w.WriteHeader(status)
+	g.template.ExecuteTemplate(w, "mfa.html", page)
+}
+
+// sealEnrollment binds the secret being enrolled to the session so the
+// confirmation post can not swap it
+func (g *Guard) sealEnrollment(session *Session, secret string) string {
+	return secret + "|" + g.cookieFactory.getCookieSignature("mfa", session.ID, secret)
+}
+
+func (g *Guard) openEnrollment(session *Session, sealed string) (string, bool) {
+	parts := strings.SplitN(sealed, "|", 2)
+	if len(parts) != 2 || parts[1] != g.cookieFactory.getCookieSignature("mfa", session.ID, parts[0]) {
+		return "", false
+	}
+	return parts[0], true
+}
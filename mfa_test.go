@@ -0,0 +1,91 @@
+package pintu
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMFACodeAcceptedOnce(t *testing.T) {
+	g := newTestGuard()
+	store, err := NewFileMFAStore(filepath.Join(t.TempDir(), "secrets.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.mfa = &mfa{store: store}
+	secret, _ := newTOTPSecret()
+	if err := store.SaveSecret(context.Background(), "alice@example.com", &MFASecret{Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+	_, session := sessionRequest(t, g, mfaPath, true)
+	csrf := csrfCookieOf(t, g.cookieFactory)
+	key, _ := totpEncoding.DecodeString(secret)
+	form := url.Values{
+		"code":    {totpCode(key, time.Now().Unix()/totpPeriod)},
+		csrfField: {g.cookieFactory.csrfToken(csrf.Value)},
+	}
+
+	const posts = 8
+	codes := make(chan int, posts)
+	var wg sync.WaitGroup
+	for i := 0; i < posts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("POST", mfaPath, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(csrf)
+			w := httptest.NewRecorder()
+			g.mfaPrompt(w, r, session)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	accepted := 0
+	for code := range codes {
+		switch code {
+		case http.StatusFound:
+			accepted++
+		case http.StatusUnauthorized:
+		default:
+			t.Fatalf("code post got %d", code)
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("the same code was accepted %d times", accepted)
+	}
+}
+
+func TestUseStep(t *testing.T) {
+	store, err := NewFileMFAStore(filepath.Join(t.TempDir(), "secrets.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := store.UseStep(ctx, "alice@example.com", 1); err != ErrMFANotEnrolled {
+		t.Fatalf("UseStep() of a user without secret = %v", err)
+	}
+	store.SaveSecret(ctx, "alice@example.com", &MFASecret{Secret: "JBSWY3DPEHPK3PXP", LastStep: 10})
+	tests := []struct {
+		step int64
+		err  error
+	}{
+		{9, ErrMFACodeUsed},
+		{10, ErrMFACodeUsed},
+		{11, nil},
+		{11, ErrMFACodeUsed},
+		{13, nil},
+	}
+	for _, test := range tests {
+		if err := store.UseStep(ctx, "Alice@example.com", test.step); err != test.err {
+			t.Errorf("UseStep(%d) = %v, want %v", test.step, err, test.err)
+		}
+	}
+}
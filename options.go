@@ -0,0 +1,269 @@
+package pintu
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+type (
+	// OptionSet is the settings namespace of pintu or of a provider
+	// instance, the options which are neither set programmatically nor on
+	// the command line are looked up in the environment then in the config
+	// file
+	// Settings priority CLI > Set > ENV > config file > default
+	OptionSet struct {
+		id      string
+		flags   *flag.FlagSet
+		config  map[string]interface{}
+		options []*option
+	}
+
+	option struct {
+		name   string
+		value  flag.Value
+		usage  string
+		set    bool
+		source string
+		// secret options resolve references from the config file and fall
+		// back to reading the file named by their <name>_file companion
+		secret bool
+		file   *string
+	}
+
+	// OptionValue is the effective value of an option and where it comes
+	// from, secrets are redacted
+	OptionValue struct {
+		Name   string
+		Env    string
+		Value  string
+		Source string
+	}
+)
+
+const (
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+	sourceOptions = "options"
+	sourceConfig  = "config"
+	redacted      = "<redacted>"
+)
+
+// NewOptionSet creates the option namespace of the instance id, the default
+// instance and pintu itself use an empty id
+func NewOptionSet(id string) *OptionSet {
+	return &OptionSet{id: id}
+}
+
+// ID returns the provider instance id
+func (s *OptionSet) ID() string {
+	return s.id
+}
+
+// Name returns the option name as seen on the command line and environment
+func (s *OptionSet) Name(option string) string {
+	return InstanceOption(s.id, option)
+}
+
+// UseConfig sets the config file section the options fall back to
+func (s *OptionSet) UseConfig(config map[string]interface{}) {
+	s.config = config
+}
+
+// Var declares an option backed by a flag.Value
+func (s *OptionSet) Var(value flag.Value, name, usage string) {
+	s.options = append(s.options, &option{name: name, value: value, usage: usage})
+}
+
+func (s *OptionSet) StringVar(p *string, name, value, usage string) {
+	*p = value
+	s.Var((*stringValue)(p), name, usage)
+}
+
+func (s *OptionSet) Int64Var(p *int64, name string, value int64, usage string) {
+	*p = value
+	s.Var((*int64Value)(p), name, usage)
+}
+
+// SecretVar declares a secret option along with its <name>_file variant
+// reading the value from a file, ie a docker or kubernetes secret, the
+// option itself takes precedence over the file
+func (s *OptionSet) SecretVar(p *string, name, usage string) {
+	file := new(string)
+	s.StringVar(p, name, "", usage)
+	secret := s.options[len(s.options)-1]
+	secret.secret = true
+	secret.file = file
+	s.StringVar(file, name+secretFileSuffix, "", "file to read "+name+" from")
+}
+
+// Set assigns an option programmatically, it takes precedence over the
+// environment and the config file
+func (s *OptionSet) Set(name, value string) error {
+	for _, o := range s.options {
+		if o.name == name {
+			o.set = true
+			o.source = sourceOptions
+			return o.value.Set(value)
+		}
+	}
+	return InvalidParam(s.Name(name), errUnknownOption)
+}
+
+// RegisterFlags declares the options on fs with their instance prefix
+func (s *OptionSet) RegisterFlags(fs *flag.FlagSet) {
+	s.flags = fs
+	for _, o := range s.options {
+		fs.Var(o.value, s.Name(o.name), o.usage)
+	}
+}
+
+// Parse resolves the options which were not set programmatically or on the
+// command line, it has to be called once the flags are parsed
+func (s *OptionSet) Parse() error {
+	var errs ValidationErrors
+
+	cli := make(map[string]bool)
+	if s.flags != nil {
+		s.flags.Visit(func(f *flag.Flag) {
+			cli[f.Name] = true
+		})
+	}
+
+	known := make(map[string]bool)
+	for _, o := range s.options {
+		name := s.Name(o.name)
+		known[o.name] = true
+		if o.set {
+			continue
+		}
+		if cli[name] {
+			o.source = sourceFlag
+			continue
+		}
+		o.source = sourceDefault
+		if l, ok := o.value.(*StringSlice); ok {
+			// lists accumulate, a reload starts them over
+			*l = nil
+		}
+		if value, env := LookupEnv(name); value != "" {
+			o.source = "env " + env
+			if err := o.value.Set(value); err != nil {
+				errs.Add(InvalidParam(name, err))
+			}
+			continue
+		}
+		if value, ok := s.config[o.name]; ok {
+			o.source = sourceConfig
+			for _, item := range configValues(value) {
+				var err error
+				if o.secret {
+					item, err = ResolveSecret(item)
+				}
+				if err == nil {
+					err = o.value.Set(item)
+				}
+				if err != nil {
+					errs.Add(InvalidParam(name, err))
+				}
+			}
+		}
+	}
+
+	// secrets left empty are read from their file variant
+	for _, o := range s.options {
+		if o.secret && o.value.String() == "" && *o.file != "" {
+			value, err := readSecretFile(*o.file)
+			if err == nil {
+				o.source = "file " + *o.file
+				err = o.value.Set(value)
+			}
+			if err != nil {
+				errs.Add(InvalidParam(s.Name(o.name+secretFileSuffix), err))
+			}
+		}
+	}
+
+	var unknown []string
+	for name := range s.config {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs.Add(InvalidParam(s.Name(name), errUnknownOption))
+	}
+	return errs.Err()
+}
+
+// Values describes the effective options once parsed
+func (s *OptionSet) Values() []OptionValue {
+	values := make([]OptionValue, 0, len(s.options))
+	for _, o := range s.options {
+		name := s.Name(o.name)
+		value := o.value.String()
+		if o.secret && value != "" {
+			value = redacted
+		}
+		source := o.source
+		if source == "" {
+			source = sourceDefault
+		}
+		values = append(values, OptionValue{
+			Name:   name,
+			Env:    EnvName(name),
+			Value:  value,
+			Source: source,
+		})
+	}
+	return values
+}
+
+// configValues flattens a config file value into flag values, lists set the
+// option once per item
+func configValues(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+const secretFileSuffix = "_file"
+
+type (
+	stringValue string
+	int64Value  int64
+)
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) String() string {
+	return string(*v)
+}
+
+func (v *int64Value) Set(s string) error {
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return errRequiresInteger
+	}
+	*v = int64Value(value)
+	return nil
+}
+
+func (v *int64Value) String() string {
+	return strconv.FormatInt(int64(*v), 10)
+}
@@ -1,47 +1,310 @@
 package pintu
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/codegangsta/negroni"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const loginPromptPath = "/auth"
+const (
+	loginPromptPath = "/auth"
+	logoutPath      = "/auth/logout"
+)
 
 type (
 	Pintu struct {
-		proxy     *negroni.Negroni
 		providers []Provider
+		options   Options
+		sessions  SessionStore
+		attempts  AttemptStore
+		metrics   *metrics
+		// mu serializes the loads, current holds the *runtime being served
+		mu      sync.Mutex
+		current atomic.Value
+		// servers are the listeners to shut down, draining fails readiness
+		serversMu sync.Mutex
+		servers   []*http.Server
+		draining  int32
+		// readiness caches the last backend checks of /ready
+		readyMu   sync.Mutex
+		readyAt   time.Time
+		readyLast Readiness
+	}
+
+	// runtime is everything rebuilt by a reload
+	runtime struct {
+		handler  http.Handler
+		settings *Settings
+		// sections are the option sets of pintu and its provider instances
+		sections []optionSection
+		// clientCertificates asks the tls clients for their certificate
+		clientCertificates bool
+		audit              *auditor
+		// checks are the backend checks of /ready
+		checks []healthCheck
+		// tracing exports the spans, nil leaves the global provider
+		tracing tracerProvider
+		// mfa is nil when the second factor is off
+		mfa *mfa
+	}
+
+	optionSection struct {
+		title   string
+		options *OptionSet
+	}
+
+	// Options configures pintu programmatically, the non zero fields act as
+	// the matching command line options so embedders can leave the flags and
+	// the config file out
+	Options struct {
+		HTTPAddress    string
+		Upstream       string
+		CookieKey      string
+		CookieSecret   string
+		CookieExpiry   int64
+		ConfigFile     string
+		AdminAddress   string
+		MetricsAddress string
+		// TLSCert and TLSKey serve https, the files are reloaded on change
+		TLSCert             string
+		TLSKey              string
+		TLSMinVersion       string
+		TLSCipherSuites     []string
+		HTTPRedirectAddress string
+		// Providers are built from the registry as <type> or <type>:<id>
+		Providers []string
+		Headers   map[string]string
+		Rules     Rules
+		Upstreams Upstreams
+		// SessionStore replaces the store of the session_store option
+		SessionStore SessionStore
+		// Logger replaces the logger built from log_format and log_level
+		Logger *slog.Logger
+		// Audit lists sinks like the audit option, AuditSinks are added to
+		// them and stay open across reloads
+		Audit      []string
+		AuditSinks []AuditSink
+		// TrustedProxies act as the trusted_proxies option
+		TrustedProxies []string
+		// OTLPEndpoint exports the traces, TracerProvider replaces the one
+		// built from it
+		OTLPEndpoint   string
+		TracerProvider trace.TracerProvider
+		// MFA and MFASecrets act as the mfa options, MFAStore replaces the
+		// secrets file
+		MFA        string
+		MFASecrets string
+		MFAStore   MFAStore
+		// Version is reported by /version, the module version when empty
+		Version string
+
+		// FlagSet receives the pintu and provider flags and parses Args,
+		// leave it nil to keep pintu off the command line
+		FlagSet *flag.FlagSet
+		Args    []string
 	}
 
 	Provider interface {
 		RegisterCookie(*CookieFactory)
 		RegisterHandler(*Guard)
 		Partial(*http.Request) string
-		ParseSettings()
+		ParseSettings() error
+		ID() string
 		Name() string
 		Type() string
 	}
 )
 
-func NewPintu() *Pintu {
-	logger := negroni.NewLogger()
-	recovery := negroni.NewRecovery()
-	proxy := negroni.New(logger, recovery)
-	return &Pintu{
-		proxy: proxy,
+func NewPintu(options Options) *Pintu {
+	p := &Pintu{options: options}
+	p.metrics = newMetrics(p.sessionStore)
+	if options.SessionStore != nil {
+		p.useSessionStore(options.SessionStore)
 	}
+	return p
 }
 
+// useSessionStore keeps the login attempts in the session store when it
+// implements AttemptStore, in memory otherwise
+func (p *Pintu) useSessionStore(sessions SessionStore) {
+	attempts, ok := sessions.(AttemptStore)
+	if !ok {
+		attempts = NewMemoryStore()
+	}
+	p.sessions = sessions
+	p.attempts = attempts
+}
+
+// sessionStore returns the session store, nil until the first load opens
+// the one of the session_store option
+func (p *Pintu) sessionStore() SessionStore {
+	return p.sessions
+}
+
+// Use adds providers built by the embedder, they are configured through
+// their own OptionSet
 func (p *Pintu) Use(providers ...Provider) {
 	p.providers = append(p.providers, providers...)
 }
 
+// Run starts pintu and exits on any settings or listener error
 func (p *Pintu) Run() {
-	settings := GetSettings()
+	if err := p.Start(); err != nil {
+		Logger().Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// Start validates the settings of pintu and its providers, all the problems
+// are returned together, then serves until the listener fails
+// SIGHUP reloads the configuration, SIGTERM and SIGINT shut down gracefully
+func (p *Pintu) Start() error {
+	if err := p.load(p.options); err != nil {
+		return err
+	}
+	settings := p.runtime().settings
+	Logger().Info("starting pintu", "version", p.BuildInfo().Version)
+	go p.reloadOnSignal()
+
+	if settings.AdminAddress != "" {
+		admin := p.newServer(settings.AdminAddress, p.adminHandler())
+		go func() {
+			Logger().Info("admin listening", "addr", settings.AdminAddress)
+			logServe(p.listenAndServe(admin))
+		}()
+	}
+
+	if settings.MetricsAddress != "" {
+		metrics := p.newServer(settings.MetricsAddress, p.MetricsHandler())
+		go func() {
+			Logger().Info("metrics listening", "addr", settings.MetricsAddress)
+			logServe(p.listenAndServe(metrics))
+		}()
+	}
+
+	errc := make(chan error, 1)
+	server := p.newServer(settings.HTTPAddress, p)
+	if settings.tlsConfig == nil {
+		Logger().Info("listening", "addr", settings.HTTPAddress)
+		go func() {
+			errc <- p.listenAndServe(server)
+		}()
+		return p.wait(errc)
+	}
+
+	if settings.HTTPRedirectAddress != "" {
+		redirect := p.newServer(settings.HTTPRedirectAddress, redirectHTTPS(settings.HTTPAddress))
+		go func() {
+			Logger().Info("redirecting http", "addr", settings.HTTPRedirectAddress)
+			logServe(p.listenAndServe(redirect))
+		}()
+	}
+	server.TLSConfig = p.serverTLSConfig(settings.tlsConfig)
+	Logger().Info("listening with tls", "addr", settings.HTTPAddress)
+	go func() {
+		errc <- p.listenAndServe(server)
+	}()
+	return p.wait(errc)
+}
+
+// Handler validates the settings like Start and returns the pintu handler
+// for embedders serving it on their own, it follows the reloads
+func (p *Pintu) Handler() (http.Handler, error) {
+	if err := p.load(p.options); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ServeHTTP serves the request with the configuration loaded last
+func (p *Pintu) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.runtime().handler.ServeHTTP(w, r)
+}
+
+func (p *Pintu) runtime() *runtime {
+	return p.current.Load().(*runtime)
+}
+
+// load builds the settings, providers and routes then swaps them in, the
+// running configuration is left untouched on error
+func (p *Pintu) load(options Options) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var previous *Settings
+	last, ok := p.current.Load().(*runtime)
+	if ok {
+		previous = last.settings
+	}
+	rt, err := p.setup(options, previous)
+	if err != nil {
+		return err
+	}
+	if options.Logger != nil {
+		logger.Store(options.Logger)
+	} else {
+		logger.Store(rt.settings.logger)
+	}
+	switch {
+	case options.TracerProvider != nil:
+		tracer.Store(tracerBox{options.TracerProvider.Tracer(tracerName)})
+	case rt.tracing != nil:
+		tracer.Store(tracerBox{rt.tracing.Tracer(tracerName)})
+	default:
+		tracer.Store(tracerBox{otel.GetTracerProvider().Tracer(tracerName)})
+	}
+	if previous != nil {
+		for _, change := range rt.settings.listenerChanges(previous) {
+			Logger().Warn("param changes require a restart", "param", change)
+		}
+	}
+	rt.settings.routes.start()
+	p.current.Store(rt)
+	if previous != nil {
+		previous.routes.Close()
+		// the previous sinks drain in the background, a slow webhook does
+		// not hold the next reload
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), auditCloseTimeout)
+			defer cancel()
+			last.audit.Close(ctx)
+		}()
+		go shutdownTracing(context.Background(), last.tracing)
+	}
+	return nil
+}
+
+// setup builds a runtime from options, the settings and option sections are
+// filled even when the configuration is invalid
+func (p *Pintu) setup(options Options, previous *Settings) (*runtime, error) {
+	rt, providers, errs := p.resolve(options)
+	settings := rt.settings
+	var err error
+	if p.sessions == nil && len(errs) == 0 {
+		// the store outlives the reloads, a changed url needs a restart
+		sessions, err := OpenSessionStore(settings.SessionStore)
+		if err != nil {
+			errs.Add(InvalidParam(optionSessionStore, err))
+		} else {
+			p.useSessionStore(sessions)
+		}
+	}
+	if previous != nil && settings.generatedSecret && previous.generatedSecret {
+		// keeps the sessions alive across reloads
+		settings.CookieSecret = previous.CookieSecret
+	}
 
 	cookieFactory := NewCookieFactory(
 		settings.CookieKey,
@@ -51,20 +314,150 @@ func (p *Pintu) Run() {
 
 	guard := NewGuard()
 	guard.cookieFactory = cookieFactory
-	guard.Use(p.providers...)
+	guard.headers = settings.Headers
+	guard.rules = settings.Rules
+	guard.upstreams = settings.routes
+	guard.sessions = p.sessions
+	guard.metrics = p.metrics
+	guard.limiter = newLoginLimiter(p.attempts, settings)
+	guard.streamCheck = time.Duration(settings.StreamCheckInterval) * time.Second
+	errs.Add(guard.Use(providers...))
+	errs.Add(checkClientCertificates(providers, settings))
+	rt.mfa, err = newMFA(options.MFAStore, settings)
+	errs.Add(err)
+	errs.Add(rt.mfa.checkProviders(providers))
+	guard.mfa = rt.mfa
 
-	// Put this to the settings validator
-	upstreamurl, err := url.Parse(settings.Upstream)
-	if err != nil {
-		log.Fatal(err)
+	if len(errs) == 0 {
+		rt.audit, err = newAuditor(settings.Audit, options.AuditSinks)
+		errs.Add(err)
 	}
+	if err := errs.Err(); err != nil {
+		return rt, err
+	}
+	if options.TracerProvider == nil {
+		if rt.tracing, err = settings.newTracerProvider(); err != nil {
+			rt.audit.Close(context.Background())
+			return rt, err
+		}
+	}
+	guard.audit = rt.audit
+	rt.clientCertificates = guard.clientCertificates()
+	rt.checks = healthChecks(guard.providers)
 
 	// Warning, the route declaration follows the order strictly
 	mux := http.NewServeMux()
-	mux.Handle("/", httputil.NewSingleHostReverseProxy(upstreamurl))
+	mux.Handle("/", p.metrics.proxy(settings.routes))
 	mux.HandleFunc(loginPromptPath, guard.LoginPrompt)
 
-	p.proxy.Use(guard)
-	p.proxy.UseHandler(mux)
-	p.proxy.Run(settings.HTTPAddress)
+	recovery := negroni.NewRecovery()
+	proxy := negroni.New(p.health(settings.HealthPrefix), accessLog(settings.trustedProxies), recovery)
+	proxy.Use(guard)
+	proxy.UseHandler(mux)
+	rt.handler = proxy
+	return rt, nil
+}
+
+// resolve reads the configuration file, the providers and the pintu options
+// without opening anything, the sections are filled even when the
+// configuration is invalid
+func (p *Pintu) resolve(options Options) (*runtime, []Provider, ValidationErrors) {
+	var errs ValidationErrors
+
+	// The config file and the providers are looked up ahead of the flags
+	// parsing, the provider instances declare their options on creation
+	config, err := LoadConfig(options.configPath())
+	errs.Add(err)
+
+	core := NewOptionSet("")
+	core.UseConfig(config.Options)
+	settings := NewSettings(core)
+	errs.Add(options.apply(core))
+	settings.Headers = config.Headers
+	if options.Headers != nil {
+		settings.Headers = options.Headers
+	}
+	settings.Rules = config.Rules
+	if options.Rules != nil {
+		settings.Rules = options.Rules
+	}
+	settings.Upstreams = config.Upstreams
+	if options.Upstreams != nil {
+		settings.Upstreams = options.Upstreams
+	}
+
+	providers := append([]Provider{}, p.providers...)
+	rt := &runtime{
+		settings: settings,
+		sections: []optionSection{{title: "pintu", options: core}},
+	}
+	enabled := make(map[string]bool)
+	for _, spec := range options.providerSpecs(config) {
+		ptype, id, err := ParseProviderSpec(spec)
+		if err != nil {
+			errs.Add(InvalidParam(optionProviders, err))
+			continue
+		}
+		if enabled[ptype+":"+id] {
+			errs.Add(InvalidParam(optionProviders, fmt.Errorf("provider %s enabled twice", spec)))
+			continue
+		}
+		enabled[ptype+":"+id] = true
+		opts := NewOptionSet(id)
+		opts.UseConfig(config.ProviderOptions(ptype, id))
+		provider, err := NewProvider(ptype, opts)
+		if err != nil {
+			errs.Add(InvalidParam(optionProviders, err))
+			continue
+		}
+		rt.sections = append(rt.sections, optionSection{title: spec, options: opts})
+		providers = append(providers, provider)
+	}
+	if len(providers) == 0 && len(errs) == 0 {
+		errs.Add(InvalidParam(optionProviders, fmt.Errorf("no provider enabled, available types are %v", ProviderTypes())))
+	}
+
+	if options.FlagSet != nil {
+		for _, section := range rt.sections {
+			section.options.RegisterFlags(options.FlagSet)
+		}
+		if err := options.FlagSet.Parse(options.Args); err != nil {
+			errs.Add(err)
+		}
+	}
+	errs.Add(settings.Parse())
+	return rt, providers, errs
+}
+
+// apply sets the non zero options on the pintu option set
+func (o Options) apply(core *OptionSet) error {
+	var errs ValidationErrors
+	values := map[string]string{
+		optionHTTPAddress:     o.HTTPAddress,
+		optionUpstream:        o.Upstream,
+		optionCookieKey:       o.CookieKey,
+		optionCookieSecret:    o.CookieSecret,
+		optionConfig:          o.ConfigFile,
+		optionAdminAddress:    o.AdminAddress,
+		optionMetricsAddress:  o.MetricsAddress,
+		optionTLSCert:         o.TLSCert,
+		optionTLSKey:          o.TLSKey,
+		optionTLSMinVersion:   o.TLSMinVersion,
+		optionTLSCipherSuites: strings.Join(o.TLSCipherSuites, ","),
+		optionHTTPRedirect:    o.HTTPRedirectAddress,
+		optionAudit:           strings.Join(o.Audit, ","),
+		optionTrustedProxies:  strings.Join(o.TrustedProxies, ","),
+		optionOTLPEndpoint:    o.OTLPEndpoint,
+		optionMFA:             o.MFA,
+		optionMFASecrets:      o.MFASecrets,
+	}
+	if o.CookieExpiry != 0 {
+		values[optionCookieExpiry] = strconv.FormatInt(o.CookieExpiry, 10)
+	}
+	for name, value := range values {
+		if value != "" {
+			errs.Add(core.Set(name, value))
+		}
+	}
+	return errs.Err()
 }
@@ -0,0 +1,355 @@
+package pintu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// HealthCheck polls every backend of a pool, a backend answering with
+	// a status below 400 is healthy
+	HealthCheck struct {
+		Path     string   `json:"path"`
+		Interval Duration `json:"interval"`
+		Timeout  Duration `json:"timeout"`
+	}
+
+	// Duration reads "10s" like durations from the config file, plain
+	// numbers are seconds
+	Duration time.Duration
+
+	// Pool balances the requests of an upstream over its backends, a
+	// backend is ejected for failTimeout after maxFails consecutive
+	// transport errors and while its health check fails
+	Pool struct {
+		name        string
+		backends    []*backend
+		balance     string
+		maxFails    int
+		failTimeout time.Duration
+		check       *HealthCheck
+		next        atomic.Uint64
+		stop        chan struct{}
+		closeOnce   sync.Once
+	}
+
+	backend struct {
+		// url is the configured backend, target the url it is reached on
+		url       *url.URL
+		target    *url.URL
+		transport *http.Transport
+		proxy     *httputil.ReverseProxy
+		active    atomic.Int64
+		requests  atomic.Uint64
+		failures  atomic.Uint64
+
+		mu           sync.Mutex
+		fails        int
+		ejectedUntil time.Time
+		unhealthy    bool
+	}
+
+	// PoolStatus reports the state of the backends of an upstream
+	PoolStatus struct {
+		Host     string          `json:"host"`
+		Path     string          `json:"path"`
+		Balance  string          `json:"balance"`
+		Backends []BackendStatus `json:"backends"`
+	}
+
+	BackendStatus struct {
+		URL      string `json:"url"`
+		Healthy  bool   `json:"healthy"`
+		Ejected  bool   `json:"ejected"`
+		Active   int64  `json:"active"`
+		Requests uint64 `json:"requests"`
+		Failures uint64 `json:"failures"`
+	}
+)
+
+const (
+	balanceRoundRobin = "round_robin"
+	balanceLeastConn  = "least_conn"
+
+	defaultMaxFails            = 1
+	defaultFailTimeout         = 10 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+)
+
+var (
+	errBalance          = errors.New("balance must be round_robin or least_conn")
+	errNoHealthyBackend = errors.New("no healthy upstream backend")
+
+	// livePools are the started pools exported through expvar
+	livePoolsMu sync.Mutex
+	livePools   = make(map[*Upstream]bool)
+)
+
+func init() {
+	expvar.Publish("pintu_upstreams", expvar.Func(func() interface{} {
+		livePoolsMu.Lock()
+		defer livePoolsMu.Unlock()
+		status := make([]PoolStatus, 0, len(livePools))
+		for u := range livePools {
+			status = append(status, u.Status())
+		}
+		return status
+	}))
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// newPool validates the upstream balancing settings and builds a proxy for
+// every backend, a nil transport uses the default one
+func newPool(u *Upstream, targets []*url.URL, transport *http.Transport) (*Pool, error) {
+	p := &Pool{
+		name:        u.Host + u.Path,
+		balance:     u.Balance,
+		maxFails:    u.MaxFails,
+		failTimeout: time.Duration(u.FailTimeout),
+		check:       u.HealthCheck,
+		stop:        make(chan struct{}),
+	}
+	switch p.balance {
+	case "":
+		p.balance = balanceRoundRobin
+	case balanceRoundRobin, balanceLeastConn:
+	default:
+		return nil, errBalance
+	}
+	if p.maxFails < 1 {
+		p.maxFails = defaultMaxFails
+	}
+	if p.failTimeout <= 0 {
+		p.failTimeout = defaultFailTimeout
+	}
+	if p.check != nil {
+		check := *p.check
+		if check.Path == "" {
+			check.Path = "/"
+		}
+		if check.Interval <= 0 {
+			check.Interval = Duration(defaultHealthCheckInterval)
+		}
+		if check.Timeout <= 0 {
+			check.Timeout = Duration(defaultHealthCheckTimeout)
+		}
+		p.check = &check
+	}
+
+	for _, target := range targets {
+		b := &backend{url: target, target: target, transport: transport}
+		if target.Scheme == unixScheme {
+			var timeout time.Duration
+			if u.Transport != nil {
+				timeout = time.Duration(u.Transport.DialTimeout)
+			}
+			// the host is only a placeholder, the transport dials the socket
+			b.target = &url.URL{Scheme: "http", Host: "localhost"}
+			b.transport = unixTransport(transport, target.Path, timeout)
+		}
+		b.proxy = httputil.NewSingleHostReverseProxy(b.target)
+		b.proxy.ErrorHandler = p.errorHandler(b)
+		b.proxy.ModifyResponse = traceResponse
+		b.proxy.FlushInterval = time.Duration(u.FlushInterval)
+		if b.transport != nil {
+			b.proxy.Transport = b.transport
+		}
+		p.backends = append(p.backends, b)
+	}
+	return p, nil
+}
+
+func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b := p.pick()
+	if b == nil {
+		DefaultError(w, r, http.StatusServiceUnavailable, "Service Unavailable", errNoHealthyBackend.Error())
+		return
+	}
+	b.active.Add(1)
+	b.requests.Add(1)
+	defer b.active.Add(-1)
+	r, span := traceUpstream(r, p.name, b)
+	defer span.End()
+	b.proxy.ServeHTTP(w, r)
+}
+
+// pick selects an available backend according to the balance mode
+func (p *Pool) pick() *backend {
+	now := time.Now()
+	n := len(p.backends)
+	start := int(p.next.Add(1) % uint64(n))
+
+	var picked *backend
+	for i := 0; i < n; i++ {
+		b := p.backends[(start+i)%n]
+		if !b.available(now) {
+			continue
+		}
+		if p.balance == balanceRoundRobin {
+			return b
+		}
+		if picked == nil || b.active.Load() < picked.active.Load() {
+			picked = b
+		}
+	}
+	return picked
+}
+
+// errorHandler counts the transport errors toward the ejection of b, the
+// requests canceled by the client are not the backend's fault and the last
+// available backend is kept like nginx does
+func (p *Pool) errorHandler(b *backend) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		span := trace.SpanFromContext(r.Context())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if r.Context().Err() != nil || errors.Is(err, context.Canceled) {
+			DefaultError(w, r, http.StatusBadGateway, "Bad Gateway", "request canceled")
+			return
+		}
+
+		b.failures.Add(1)
+		others := p.othersAvailable(b, time.Now())
+		b.mu.Lock()
+		b.fails++
+		if b.fails >= p.maxFails && others {
+			b.fails = 0
+			b.ejectedUntil = time.Now().Add(p.failTimeout)
+			RequestLogger(r).Warn("upstream backend ejected", "backend", b.url.String(), "for", p.failTimeout.String(), "err", err.Error())
+		}
+		b.mu.Unlock()
+		DefaultError(w, r, http.StatusBadGateway, "Bad Gateway", "upstream unavailable")
+	}
+}
+
+// othersAvailable reports whether a backend other than b takes requests
+func (p *Pool) othersAvailable(b *backend, now time.Time) bool {
+	for _, other := range p.backends {
+		if other != b && other.available(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// start runs the health checks until Close
+func (p *Pool) start() {
+	if p.check == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(p.check.Interval))
+		defer ticker.Stop()
+		for {
+			for _, b := range p.backends {
+				b.probe(time.Duration(p.check.Timeout), p.check.Path)
+			}
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the health checks and drops the idle connections
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		for _, b := range p.backends {
+			if b.transport != nil {
+				b.transport.CloseIdleConnections()
+			}
+		}
+	})
+}
+
+func (b *backend) available(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.unhealthy && now.After(b.ejectedUntil)
+}
+
+func (b *backend) probe(timeout time.Duration, path string) {
+	client := &http.Client{Timeout: timeout}
+	if b.transport != nil {
+		client.Transport = b.transport
+	}
+	target := *b.target
+	target.Path = singleJoiningSlash(target.Path, path)
+	resp, err := client.Get(target.String())
+	healthy := err == nil && resp.StatusCode < 400
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if healthy == b.unhealthy {
+		Logger().Warn("upstream backend health changed", "backend", b.url.String(), "healthy", healthy)
+	}
+	b.unhealthy = !healthy
+	if healthy {
+		b.fails = 0
+	}
+}
+
+func (b *backend) status(now time.Time) BackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BackendStatus{
+		URL:      b.url.String(),
+		Healthy:  !b.unhealthy,
+		Ejected:  now.Before(b.ejectedUntil),
+		Active:   b.active.Load(),
+		Requests: b.requests.Load(),
+		Failures: b.failures.Load(),
+	}
+}
+
+func singleJoiningSlash(a, b string) string {
+	switch {
+	case len(a) > 0 && a[len(a)-1] == '/' && len(b) > 0 && b[0] == '/':
+		return a + b[1:]
+	case (len(a) == 0 || a[len(a)-1] != '/') && (len(b) == 0 || b[0] != '/'):
+		return a + "/" + b
+	}
+	return a + b
+}
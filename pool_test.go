@@ -0,0 +1,173 @@
+package pintu
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestPool balances over the urls with the settings of u
+func newTestPool(t *testing.T, u *Upstream, urls ...string) *Pool {
+	t.Helper()
+	targets := make([]*url.URL, len(urls))
+	for i, raw := range urls {
+		targets[i], _ = url.Parse(raw)
+	}
+	p, err := newPool(u, targets, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+// deadURL is a backend url nothing listens on
+func deadURL(t *testing.T) string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+func serveStatus(p *Pool, ctx context.Context) int {
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	return w.Code
+}
+
+func TestPoolCanceledRequestsDoNotEject(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	p := newTestPool(t, &Upstream{}, backend.URL, backend.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for range p.backends {
+		serveStatus(p, ctx)
+	}
+	now := time.Now()
+	for _, b := range p.backends {
+		if !b.available(now) || b.failures.Load() != 0 {
+			t.Fatalf("a canceled request counted as a failure of %s", b.url)
+		}
+	}
+	if code := serveStatus(p, context.Background()); code != http.StatusOK {
+		t.Fatalf("ServeHTTP() after canceled requests = %d", code)
+	}
+}
+
+func TestPoolKeepsLastBackend(t *testing.T) {
+	p := newTestPool(t, &Upstream{}, deadURL(t))
+	for i := 0; i < 3; i++ {
+		if code := serveStatus(p, context.Background()); code != http.StatusBadGateway {
+			t.Fatalf("ServeHTTP() = %d, want %d", code, http.StatusBadGateway)
+		}
+	}
+	if !p.backends[0].available(time.Now()) {
+		t.Fatal("the last available backend was ejected")
+	}
+}
+
+// namedBackend answers its name, with a 503 on /healthz while down is set
+func namedBackend(t *testing.T, name string, down *atomic.Bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && down != nil && down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// served counts the backend names answering n requests
+func served(p *Pool, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusOK {
+			counts[http.StatusText(w.Code)]++
+			continue
+		}
+		counts[w.Body.String()]++
+	}
+	return counts
+}
+
+// eventually polls cond for a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never happened", what)
+		}
+	}
+}
+
+func TestPoolBalance(t *testing.T) {
+	a, b := namedBackend(t, "a", nil), namedBackend(t, "b", nil)
+	tests := []struct {
+		balance string
+		busy    int64
+		want    map[string]int
+	}{
+		{"", 0, map[string]int{"a": 4, "b": 4}},
+		{balanceRoundRobin, 3, map[string]int{"a": 4, "b": 4}},
+		{balanceLeastConn, 0, map[string]int{"a": 4, "b": 4}},
+		{balanceLeastConn, 3, map[string]int{"b": 8}},
+	}
+	for _, test := range tests {
+		p := newTestPool(t, &Upstream{Balance: test.balance}, a.URL, b.URL)
+		p.backends[0].active.Add(test.busy)
+		got := served(p, 8)
+		if len(got) != len(test.want) || got["a"] != test.want["a"] || got["b"] != test.want["b"] {
+			t.Errorf("balance %q with a busy by %d served %v, want %v", test.balance, test.busy, got, test.want)
+		}
+	}
+	if _, err := newPool(&Upstream{Balance: "random"}, nil, nil); err != errBalance {
+		t.Fatalf("newPool() with an unknown balance = %v", err)
+	}
+}
+
+func TestPoolEjection(t *testing.T) {
+	alive := namedBackend(t, "alive", nil)
+	p := newTestPool(t, &Upstream{MaxFails: 2, FailTimeout: Duration(100 * time.Millisecond)}, deadURL(t), alive.URL)
+	dead := p.backends[0]
+
+	got := served(p, 4)
+	if got["alive"] != 2 || got[http.StatusText(http.StatusBadGateway)] != 2 {
+		t.Fatalf("served %v before the ejection", got)
+	}
+	if status := dead.status(time.Now()); !status.Ejected || status.Failures != 2 {
+		t.Fatalf("dead backend %+v, want ejected after 2 failures", status)
+	}
+	if got := served(p, 4); got["alive"] != 4 {
+		t.Fatalf("served %v with the dead backend ejected", got)
+	}
+	eventually(t, "the end of the ejection", func() bool { return dead.available(time.Now()) })
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	var down atomic.Bool
+	flaky, steady := namedBackend(t, "flaky", &down), namedBackend(t, "steady", nil)
+	check := &HealthCheck{Path: "/healthz", Interval: Duration(10 * time.Millisecond)}
+	p := newTestPool(t, &Upstream{HealthCheck: check}, flaky.URL, steady.URL)
+	p.start()
+
+	down.Store(true)
+	eventually(t, "the failing health check", func() bool { return !p.backends[0].status(time.Now()).Healthy })
+	if got := served(p, 4); got["steady"] != 4 {
+		t.Fatalf("served %v with an unhealthy backend", got)
+	}
+	down.Store(false)
+	eventually(t, "the passing health check", func() bool { return p.backends[0].available(time.Now()) })
+	if got := served(p, 4); got["flaky"] != 2 || got["steady"] != 2 {
+		t.Fatalf("served %v once healthy again", got)
+	}
+}
@@ -0,0 +1,99 @@
+package pintu
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// sensitiveHeaders have their value redacted when printed
+var sensitiveHeaders = []string{"auth", "cookie", "key", "secret", "token"}
+
+// PrintConfig writes the effective configuration of pintu and its provider
+// instances along with where every value comes from, secrets are redacted
+// and the validation errors are returned once everything is printed. The
+// configuration is validated without opening the session store, the audit
+// sinks or the trace exporter
+func (p *Pintu) PrintConfig(w io.Writer) error {
+	rt, providers, errs := p.resolve(p.options)
+	for _, provider := range providers {
+		errs.Add(provider.ParseSettings())
+	}
+	errs.Add(checkClientCertificates(providers, rt.settings))
+	mfa, err := newMFA(p.options.MFAStore, rt.settings)
+	errs.Add(err)
+	errs.Add(mfa.checkProviders(providers))
+	err = errs.Err()
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, section := range rt.sections {
+		fmt.Fprintf(tw, "[%s]\n", section.title)
+		fmt.Fprintln(tw, "OPTION\tENV\tVALUE\tSOURCE")
+		for _, v := range section.options.Values() {
+			if section.title == "pintu" && v.Name == optionUpstream {
+				v.Value = redactURL(v.Value)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%q\t%s\n", v.Name, v.Env, v.Value, v.Source)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	if len(rt.settings.Headers) > 0 {
+		names := make([]string, 0, len(rt.settings.Headers))
+		for name := range rt.settings.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(tw, "[%s]\n", configHeaders)
+		for _, name := range names {
+			fmt.Fprintf(tw, "%s\t%q\n", name, headerValue(name, rt.settings.Headers[name]))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	if len(rt.settings.Rules) > 0 {
+		fmt.Fprintf(tw, "[%s]\n", configRules)
+		fmt.Fprintln(tw, "PATH\tEMAILS\tDOMAINS")
+		for _, rule := range rt.settings.Rules {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", rule.Path, strings.Join(rule.Emails, ","), strings.Join(rule.Domains, ","))
+		}
+		fmt.Fprintln(tw)
+	}
+	if len(rt.settings.routes) > 0 {
+		fmt.Fprintf(tw, "[%s]\n", configUpstreams)
+		fmt.Fprintln(tw, "HOST\tPATH\tURL\tSTRIP_PREFIX\tRULES")
+		for _, u := range rt.settings.routes {
+			backends := append([]string{}, u.backends()...)
+			for i, backend := range backends {
+				backends[i] = redactURL(backend)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%d\n", u.Host, u.Path, strings.Join(backends, ","), u.StripPrefix, len(u.Rules))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	return err
+}
+
+// redactURL hides the password of the userinfo of an upstream url, an
+// unparsable url is redacted whole
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	return u.Redacted()
+}
+
+func headerValue(name, value string) string {
+	lower := strings.ToLower(name)
+	for _, sensitive := range sensitiveHeaders {
+		if strings.Contains(lower, sensitive) {
+			return redacted
+		}
+	}
+	return value
+}
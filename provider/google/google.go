@@ -2,10 +2,9 @@ package google
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
@@ -15,16 +14,15 @@ import (
 
 type (
 	GoogleOauthProvider struct {
-		name          string
-		start         string
-		redirect      string
-		redemption    *url.URL
-		login         *url.URL
-		userInfo      *url.URL
-		scopes        string
-		settings      *settings
-		cookieFactory *pintu.CookieFactory
-		ptype         string
+		id         string
+		name       string
+		path       string
+		redemption *url.URL
+		login      *url.URL
+		userInfo   *url.URL
+		scopes     string
+		settings   *settings
+		options    *pintu.OptionSet
 	}
 
 	settings struct {
@@ -45,87 +43,70 @@ var (
 	errDomainMismatch = errors.New("domain mismatch")
 )
 
+func init() {
+	pintu.RegisterAuthenticator("google", func(opts *pintu.OptionSet) pintu.Authenticator {
+		return NewGoogleOauthProvider(opts)
+	})
+}
+
 // NewGoogleOauthProvider bootstrap handler and authenticator
-func NewGoogleOauthProvider() *GoogleOauthProvider {
+func NewGoogleOauthProvider(opts *pintu.OptionSet) *GoogleOauthProvider {
 	redemption, _ := url.Parse("https://accounts.google.com/o/oauth2/token")
 	login, _ := url.Parse("https://accounts.google.com/o/oauth2/auth")
 	userInfo, _ := url.Parse("https://www.googleapis.com/oauth2/v2/userinfo")
 	scopes := "https://www.googleapis.com/auth/userinfo.profile https://www.googleapis.com/auth/userinfo.email"
 
 	s := &settings{}
-	flag.StringVar(&s.clientId, optionGoogleClientId, "", "Google OAuth client ID: ie: \"123456.apps.googleusercontent.com\"")
-	flag.StringVar(&s.secret, optionGoogleClientSecret, "", "Google OAuth client secret")
-	flag.Var(&s.domains, optionGoogleDomain, "Google Apps domain")
+	opts.StringVar(&s.clientId, optionGoogleClientId, "", "Google OAuth client ID: ie: \"123456.apps.googleusercontent.com\"")
+	opts.SecretVar(&s.secret, optionGoogleClientSecret, "Google OAuth client secret")
+	opts.Var(&s.domains, optionGoogleDomain, "Google Apps domain")
 
 	return &GoogleOauthProvider{
-		name:       "Google",
-		start:      "/oauth2/google/start",
-		redirect:   "/oauth2/google/callback",
+		id:         opts.ID(),
+		name:       pintu.InstanceName("Google", opts.ID()),
+		path:       pintu.InstancePath("/oauth2/google", opts.ID()),
 		redemption: redemption,
 		login:      login,
 		userInfo:   userInfo,
 		scopes:     scopes,
 		settings:   s,
-		ptype:      "link",
+		options:    opts,
 	}
 }
 
-func (p *GoogleOauthProvider) ParseSettings() {
+func (p *GoogleOauthProvider) ParseSettings() error {
+	if err := p.options.Parse(); err != nil {
+		return err
+	}
+	var errs pintu.ValidationErrors
 	if p.settings.clientId == "" {
-		pintu.EnvStringVar(&p.settings.clientId, optionGoogleClientId, "")
-		if p.settings.clientId == "" {
-			log.Fatalf("missing param %s", optionGoogleClientId)
-		}
+		errs.Add(pintu.MissingParam(p.options.Name(optionGoogleClientId)))
 	}
-
 	if p.settings.secret == "" {
-		pintu.EnvStringVar(&p.settings.secret, optionGoogleClientSecret, "")
-		if p.settings.secret == "" {
-			log.Fatalf("missing param %s", optionGoogleClientSecret)
-		}
-	}
-
-	if len(p.settings.domains) == 0 {
-		pintu.EnvStringSliceVar(&p.settings.domains, optionGoogleDomain)
+		errs.Add(pintu.MissingParam(p.options.Name(optionGoogleClientSecret)))
 	}
+	return errs.Err()
 }
 
-func (p *GoogleOauthProvider) RegisterCookie(f *pintu.CookieFactory) {
-	p.cookieFactory = f
+func (p *GoogleOauthProvider) ID() string {
+	return p.id
 }
 
-func (p *GoogleOauthProvider) RegisterHandler(g *pintu.Guard) {
-	g.HandleFunc(p.start, p.startHandler)
-	g.HandleFunc(p.redirect, p.redirectHandler)
-}
-
-// Partial provides AuthProxy rendered Partial of Login Form
-func (p *GoogleOauthProvider) Partial(r *http.Request) string {
-	hostURL := pintu.GetHostURL(r)
-	action := hostURL + p.start
-	partial := &pintu.LoginPartial{
-		Action:   action,
-		Redirect: r.URL.RequestURI(),
-		Name:     p.name,
-		Type:     "btn-google-plus",
-		Btn:      "fa-google",
-	}
-	return partial.GetLink(r)
+func (p *GoogleOauthProvider) Name() string {
+	return p.name
 }
 
-func (p *GoogleOauthProvider) Type() string {
-	return p.ptype
+func (p *GoogleOauthProvider) Path() string {
+	return p.path
 }
 
-func (p *GoogleOauthProvider) Name() string {
-	return p.name
+// Button styles the login link
+func (p *GoogleOauthProvider) Button() (class, icon string) {
+	return "btn-google-plus", "fa-google"
 }
 
-// GetLoginURL compile redirect url to provider's portal
-func (p *GoogleOauthProvider) loginURL(r *http.Request) (string, error) {
-	state := pintu.GetRedirect(r)
-	callback := pintu.GetHostPath(r, p.redirect)
-
+// BeginLogin compiles redirect url to provider's portal
+func (p *GoogleOauthProvider) BeginLogin(r *http.Request, callback, state string) (string, error) {
 	params := url.Values{}
 	params.Add("redirect_uri", callback)
 	params.Add("prompt", "select_account")
@@ -138,13 +119,10 @@ func (p *GoogleOauthProvider) loginURL(r *http.Request) (string, error) {
 }
 
 // redeem consumes authorization code acquired
-func (p *GoogleOauthProvider) redeem(r *http.Request) (string, error) {
-	code := r.Form.Get("code")
-	if code == "" {
-		return "", errMissingCode
-	}
+func (p *GoogleOauthProvider) redeem(ctx context.Context, code, callback string) (token string, err error) {
+	ctx, span := pintu.StartSpan(ctx, "oauth.exchange")
+	defer func() { pintu.EndSpan(span, err) }()
 
-	callback := pintu.GetHostPath(r, p.redirect)
 	params := url.Values{}
 	params.Add("redirect_uri", callback)
 	params.Add("client_id", p.settings.clientId)
@@ -152,9 +130,8 @@ func (p *GoogleOauthProvider) redeem(r *http.Request) (string, error) {
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
 
-	req, err := http.NewRequest("POST", p.redemption.String(), bytes.NewBufferString(params.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.redemption.String(), bytes.NewBufferString(params.Encode()))
 	if err != nil {
-		log.Printf("failed building request %s", err.Error())
 		return "", err
 	}
 
@@ -162,95 +139,66 @@ func (p *GoogleOauthProvider) redeem(r *http.Request) (string, error) {
 
 	json, err := pintu.APIRequest(req)
 	if err != nil {
-		log.Printf("failed making request %s", err.Error())
 		return "", err
 	}
 
-	accessToken, err := json.Get("access_token").String()
+	token, err = json.Get("access_token").String()
 	if err != nil {
 		return "", err
 	}
-	return accessToken, nil
+	return token, nil
 }
 
 // GetUserInfo retrieves user information from provider
-func (p *GoogleOauthProvider) getinfo(token string) (string, error) {
-	params := url.Values{}
-	params.Add("access_token", token)
-	endpoint := fmt.Sprintf("%s?%s", p.userInfo.String(), params.Encode())
+func (p *GoogleOauthProvider) getinfo(ctx context.Context, token string) (email string, err error) {
+	ctx, span := pintu.StartSpan(ctx, "oauth.userinfo")
+	defer func() { pintu.EndSpan(span, err) }()
 
-	req, err := http.NewRequest("GET", endpoint, nil)
+	// the token goes in a header, urls end up in error messages
+	req, err := http.NewRequestWithContext(ctx, "GET", p.userInfo.String(), nil)
 	if err != nil {
-		log.Printf("failed building request %s", err.Error())
 		return "", err
 	}
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	json, err := pintu.APIRequest(req)
 	if err != nil {
-		log.Printf("failed making request %s", err.Error())
 		return "", err
 	}
 
-	email, err := json.Get("email").String()
+	email, err = json.Get("email").String()
 	if err != nil {
-		log.Printf("failed getting email from response %s", err.Error())
 		return "", err
 	}
 	return email, nil
 }
 
-func (p *GoogleOauthProvider) startHandler(w http.ResponseWriter, r *http.Request) {
-	login, err := p.loginURL(r)
-	if err != nil {
-		pintu.CustomError(w, r, pintu.ErrAuthServerDown)
-		return
+// CompleteLogin redeems the authorization code and checks the user domain
+func (p *GoogleOauthProvider) CompleteLogin(r *http.Request, callback string) (*pintu.Identity, string, error) {
+	if r.Form.Get("error") != "" {
+		return nil, "", pintu.ErrAccessDenied
 	}
-	http.Redirect(w, r, login, 302)
-	return
-}
 
-func (p *GoogleOauthProvider) redirectHandler(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-	if err != nil {
-		pintu.CustomError(w, r, err)
-		return
-	}
-
-	errResp := r.Form.Get("error")
-	if errResp != "" {
-		pintu.Denied(w, r)
-		return
+	code := r.Form.Get("code")
+	if code == "" {
+		return nil, "", errMissingCode
 	}
 
-	token, err := p.redeem(r)
+	token, err := p.redeem(r.Context(), code, callback)
 	if err != nil {
-		log.Printf("error redeeming code %s", err.Error())
-		pintu.CustomError(w, r, err)
-		return
+		return nil, "", pintu.BackendError(fmt.Errorf("redeeming code: %s", err.Error()))
 	}
 
-	email, err := p.getinfo(token)
+	email, err := p.getinfo(r.Context(), token)
 	if err != nil {
-		log.Printf("error redeeming code %s", err.Error())
-		pintu.CustomError(w, r, err)
-		return
+		return nil, "", pintu.BackendError(fmt.Errorf("getting user info: %s", err.Error()))
 	}
 
-	redirect := r.Form.Get("state")
-	if redirect == "" {
-		redirect = "/"
-	}
-
-	log.Printf("validating againsts domains %v", p.settings.domains)
+	pintu.RequestLogger(r).Debug("validating domains", "user", email, "domains", p.settings.domains)
 	if !p.validate(email) {
-		pintu.CustomError(w, r, errDomainMismatch)
-		return
+		return nil, "", errDomainMismatch
 	}
-
-	log.Printf("authenticating %s completed", email)
-	p.cookieFactory.SetCookie(email, w, r)
-	http.Redirect(w, r, redirect, 302)
-	return
+	return &pintu.Identity{Email: email}, r.Form.Get("state"), nil
 }
 
 func (p *GoogleOauthProvider) validate(email string) bool {
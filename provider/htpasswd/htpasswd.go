@@ -1,21 +1,19 @@
 package htpasswd
 
 import (
-	"flag"
-	"log"
-	"net/http"
+	"context"
 
 	"github.com/Tuxuri/pintu"
 )
 
 type (
 	HtpasswdProvider struct {
-		name          string
-		start         string
-		settings      *settings
-		cookieFactory *pintu.CookieFactory
-		ptype         string
-		htpasswdfile  *HtpasswdFile
+		id           string
+		name         string
+		path         string
+		settings     *settings
+		options      *pintu.OptionSet
+		htpasswdfile *HtpasswdFile
 	}
 
 	settings struct {
@@ -27,72 +25,58 @@ const (
 	optionPath = "htpasswd"
 )
 
-func NewHtpasswdProvider() *HtpasswdProvider {
+func init() {
+	pintu.RegisterAuthenticator("htpasswd", func(opts *pintu.OptionSet) pintu.Authenticator {
+		return NewHtpasswdProvider(opts)
+	})
+}
+
+// NewHtpasswdProvider creates the htpasswd instance declaring its options on opts
+func NewHtpasswdProvider(opts *pintu.OptionSet) *HtpasswdProvider {
 	s := &settings{}
-	flag.StringVar(&s.path, optionPath, "", "htpasswd file path")
+	opts.StringVar(&s.path, optionPath, "", "htpasswd file path")
 
 	return &HtpasswdProvider{
-		name:     "HTPasswd",
-		start:    "/auth/htpasswd/start",
+		id:       opts.ID(),
+		name:     pintu.InstanceName("HTPasswd", opts.ID()),
+		path:     pintu.InstancePath("/auth/htpasswd", opts.ID()),
 		settings: s,
-		ptype:    "form",
+		options:  opts,
 	}
 }
 
-func (p *HtpasswdProvider) ParseSettings() {
+func (p *HtpasswdProvider) ParseSettings() error {
+	if err := p.options.Parse(); err != nil {
+		return err
+	}
+	option := p.options.Name(optionPath)
 	if p.settings.path == "" {
-		pintu.EnvStringVar(&p.settings.path, optionPath, "")
-		if p.settings.path == "" {
-			log.Fatalf("missing param %s", optionPath)
-		}
-		var err error
-		p.htpasswdfile, err = NewHtpasswdFromFile(p.settings.path)
-		if err != nil {
-			log.Fatal(err)
-		}
+		return pintu.MissingParam(option)
 	}
-}
-
-func (p *HtpasswdProvider) RegisterCookie(f *pintu.CookieFactory) {
-	p.cookieFactory = f
-}
-
-func (p *HtpasswdProvider) RegisterHandler(g *pintu.Guard) {
-	g.HandleFunc(p.start, p.startHandler)
-}
-
-func (p *HtpasswdProvider) Partial(r *http.Request) string {
-	hostURL := pintu.GetHostURL(r)
-	action := hostURL + p.start
-	partial := &pintu.LoginPartial{
-		Action:   action,
-		Redirect: r.URL.RequestURI(),
-		Name:     p.name,
+	var err error
+	p.htpasswdfile, err = NewHtpasswdFromFile(p.settings.path)
+	if err != nil {
+		return pintu.InvalidParam(option, err)
 	}
-	return partial.GetForm(r)
+	return nil
 }
 
-func (p *HtpasswdProvider) Type() string {
-	return p.ptype
+func (p *HtpasswdProvider) ID() string {
+	return p.id
 }
 
 func (p *HtpasswdProvider) Name() string {
 	return p.name
 }
 
-func (p *HtpasswdProvider) startHandler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		return
-	}
-	email := r.Form.Get("username")
-	password := r.Form.Get("password")
-	redirect := pintu.GetRedirect(r)
+func (p *HtpasswdProvider) Path() string {
+	return p.path
+}
 
-	if p.htpasswdfile.Validate(email, password) {
-		p.cookieFactory.SetCookie(email, w, r)
-		http.Redirect(w, r, redirect, 302)
-		return
+// Authenticate checks the password against the htpasswd file
+func (p *HtpasswdProvider) Authenticate(ctx context.Context, username, password string) (*pintu.Identity, error) {
+	if !p.htpasswdfile.Validate(username, password) {
+		return nil, pintu.ErrInvalidCredentials
 	}
-	pintu.CustomError(w, r, pintu.ErrInvalidCredentials)
-	return
+	return &pintu.Identity{Email: username, Username: username}, nil
 }
@@ -5,8 +5,9 @@ import (
 	"encoding/base64"
 	"encoding/csv"
 	"io"
-	"log"
 	"os"
+
+	"github.com/Tuxuri/pintu"
 )
 
 // lookup passwords in a htpasswd file
@@ -17,7 +18,7 @@ type HtpasswdFile struct {
 }
 
 func NewHtpasswdFromFile(path string) (*HtpasswdFile, error) {
-	log.Printf("using htpasswd file %s", path)
+	pintu.Logger().Info("using htpasswd file", "file", path)
 	r, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -54,7 +55,7 @@ func (h *HtpasswdFile) Validate(user string, password string) bool {
 			return true
 		}
 	} else {
-		log.Printf("Invalid htpasswd entry for %s. Must be a SHA entry.", user)
+		pintu.Logger().Warn("invalid htpasswd entry, must be a SHA entry", "user", user)
 	}
 	return false
 }
@@ -1,9 +1,11 @@
+//go:build ldap && cgo
+// +build ldap,cgo
+
 package ldap
 
 import (
-	"flag"
-	"log"
-	"net/http"
+	"context"
+	"strings"
 
 	"github.com/Tuxuri/pintu"
 	"github.com/mqu/openldap"
@@ -11,15 +13,16 @@ import (
 
 type (
 	LdapProvider struct {
-		name          string
-		start         string
-		settings      *settings
-		cookieFactory *pintu.CookieFactory
-		ptype         string
+		id       string
+		name     string
+		path     string
+		settings *settings
+		options  *pintu.OptionSet
 	}
 
 	settings struct {
 		ldapServer string
+		servers    []string
 		baseDN     string // Not required yet
 	}
 )
@@ -29,75 +32,80 @@ const (
 	optionBaseDN     = "ldap_base_dn"
 )
 
-func NewLdapProvider() *LdapProvider {
+func init() {
+	pintu.RegisterAuthenticator("ldap", func(opts *pintu.OptionSet) pintu.Authenticator {
+		return NewLdapProvider(opts)
+	})
+}
+
+// NewLdapProvider creates the LDAP instance declaring its options on opts
+func NewLdapProvider(opts *pintu.OptionSet) *LdapProvider {
 	s := &settings{}
-	flag.StringVar(&s.ldapServer, optionLdapServer, "", "LDAP Server URI ie ldap://127.0.0.1:389")
+	opts.StringVar(&s.ldapServer, optionLdapServer, "", "LDAP Server URI ie ldap://127.0.0.1:389, space separated for the fail-over servers")
 	return &LdapProvider{
-		name:     "LDAP",
-		start:    "/auth/ldap/start",
+		id:       opts.ID(),
+		name:     pintu.InstanceName("LDAP", opts.ID()),
+		path:     pintu.InstancePath("/auth/ldap", opts.ID()),
 		settings: s,
-		ptype:    "form",
+		options:  opts,
 	}
 }
 
-func (p *LdapProvider) ParseSettings() {
-	if p.settings.ldapServer == "" {
-		pintu.EnvStringVar(&p.settings.ldapServer, optionLdapServer, "")
-		if p.settings.ldapServer == "" {
-			log.Fatalf("missing param %s", optionLdapServer)
-		}
+func (p *LdapProvider) ParseSettings() error {
+	if err := p.options.Parse(); err != nil {
+		return err
+	}
+	option := p.options.Name(optionLdapServer)
+	servers, err := parseServers(p.settings.ldapServer)
+	if err != nil {
+		return pintu.InvalidParam(option, err)
 	}
+	if len(servers) == 0 {
+		return pintu.MissingParam(option)
+	}
+	p.settings.servers = servers
+	return nil
 }
 
-func (p *LdapProvider) RegisterCookie(f *pintu.CookieFactory) {
-	p.cookieFactory = f
+func (p *LdapProvider) ID() string {
+	return p.id
 }
 
-func (p *LdapProvider) RegisterHandler(g *pintu.Guard) {
-	g.HandleFunc(p.start, p.startHandler)
+func (p *LdapProvider) Name() string {
+	return p.name
 }
 
-func (p *LdapProvider) Partial(r *http.Request) string {
-	hostURL := pintu.GetHostURL(r)
-	action := hostURL + p.start
-	partial := &pintu.LoginPartial{
-		Action:   action,
-		Redirect: r.URL.RequestURI(),
-		Name:     p.name,
-	}
-	return partial.GetForm(r)
+func (p *LdapProvider) Path() string {
+	return p.path
 }
 
-func (p *LdapProvider) Type() string {
-	return p.ptype
+// Authenticate binds against the LDAP server with the user credentials
+func (p *LdapProvider) Authenticate(ctx context.Context, username, password string) (*pintu.Identity, error) {
+	_, span := pintu.StartSpan(ctx, "ldap.bind")
+	err := p.bind(username, password)
+	pintu.EndSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &pintu.Identity{Email: username, Username: username}, nil
 }
 
-func (p *LdapProvider) Name() string {
-	return p.name
+// CheckHealth dials the LDAP servers, a bind would need credentials
+func (p *LdapProvider) CheckHealth(ctx context.Context) error {
+	return probeServers(ctx, p.settings.servers)
 }
 
-func (p *LdapProvider) startHandler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		return
-	}
-	email := r.Form.Get("username")
-	password := r.Form.Get("password")
-	redirect := pintu.GetRedirect(r)
-
-	ldap, err := openldap.Initialize(p.settings.ldapServer)
+func (p *LdapProvider) bind(username, password string) error {
+	ldap, err := openldap.Initialize(strings.Join(p.settings.servers, " "))
 	if err != nil {
-		log.Println(err)
-		pintu.CustomError(w, r, pintu.ErrAuthServerDown)
-		return
+		pintu.Logger().Error("ldap server unreachable", "provider", p.name, "err", err.Error())
+		return pintu.ErrAuthServerDown
 	}
+	defer ldap.Close()
+
 	ldap.SetOption(openldap.LDAP_OPT_PROTOCOL_VERSION, openldap.LDAP_VERSION3)
-	err = ldap.Bind(email, password)
-	if err != nil {
-		pintu.CustomError(w, r, pintu.ErrInvalidCredentials)
-		return
-	} else {
-		p.cookieFactory.SetCookie(email, w, r)
-		http.Redirect(w, r, redirect, 302)
+	if err := ldap.Bind(username, password); err != nil {
+		return pintu.ErrInvalidCredentials
 	}
-	defer ldap.Close()
+	return nil
 }
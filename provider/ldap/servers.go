@@ -0,0 +1,80 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// The server list and its probe need no libldap, they build without the
+// ldap tag
+
+var errLdapServer = errors.New("requires space separated ldap://, ldaps:// or ldapi:// uris")
+
+// parseServers splits the space separated uris of ldap_server
+func parseServers(value string) ([]string, error) {
+	servers := strings.Fields(value)
+	for _, server := range servers {
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, errLdapServer
+		}
+		switch u.Scheme {
+		case "ldapi":
+		case "ldap", "ldaps":
+			if u.Hostname() == "" {
+				return nil, errLdapServer
+			}
+		default:
+			return nil, errLdapServer
+		}
+	}
+	return servers, nil
+}
+
+// probeServers dials the servers at once, libldap fails over between them
+// so any one answering is enough, the first error is returned otherwise
+func probeServers(ctx context.Context, servers []string) error {
+	errc := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server string) {
+			errc <- probeServer(ctx, server)
+		}(server)
+	}
+	var first error
+	for range servers {
+		if err := <-errc; err == nil {
+			return nil
+		} else if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func probeServer(ctx context.Context, server string) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "ldapi" {
+		// a local socket, nothing to reach over the network
+		return nil
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "389"
+		if u.Scheme == "ldaps" {
+			port = "636"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
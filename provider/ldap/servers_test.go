@@ -0,0 +1,56 @@
+package ldap
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseServers(t *testing.T) {
+	tests := []struct {
+		value   string
+		servers []string
+		valid   bool
+	}{
+		{"ldap://a.example.com", []string{"ldap://a.example.com"}, true},
+		{"  ldap://a.example.com:389 \tldaps://b.example.com ", []string{"ldap://a.example.com:389", "ldaps://b.example.com"}, true},
+		{"ldapi:///var/run/slapd.sock", []string{"ldapi:///var/run/slapd.sock"}, true},
+		{"   ", nil, true},
+		{"a.example.com", nil, false},
+		{"ldap://", nil, false},
+		{"http://a.example.com", nil, false},
+	}
+	for _, test := range tests {
+		servers, err := parseServers(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("parseServers(%q) error = %v", test.value, err)
+			continue
+		}
+		if test.valid && len(servers)+len(test.servers) > 0 && !reflect.DeepEqual(servers, test.servers) {
+			t.Errorf("parseServers(%q) = %q, want %q", test.value, servers, test.servers)
+		}
+	}
+}
+
+func TestProbeServers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	up := "ldap://" + listener.Addr().String()
+	down := "ldap://" + closed.Addr().String()
+	if err := probeServers(ctx, []string{down, up}); err != nil {
+		t.Fatalf("probeServers() with one server up = %v", err)
+	}
+	if err := probeServers(ctx, []string{down}); err == nil {
+		t.Fatal("probeServers() with every server down succeeded")
+	}
+}
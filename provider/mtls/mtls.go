@@ -0,0 +1,226 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Tuxuri/pintu"
+)
+
+type (
+	// MTLSProvider authenticates the requests carrying a client certificate
+	// signed by the configured CA, pintu has to terminate tls itself
+	MTLSProvider struct {
+		id       string
+		name     string
+		path     string
+		settings *settings
+		options  *pintu.OptionSet
+		roots    *x509.CertPool
+		revoked  map[revokedKey]bool
+	}
+
+	// revokedKey names a certificate by its issuer, the serial numbers are
+	// only unique per CA
+	revokedKey struct {
+		issuer string
+		serial string
+	}
+
+	settings struct {
+		ca     string
+		crl    string
+		domain string
+	}
+)
+
+const (
+	optionCA     = "mtls_ca"
+	optionCRL    = "mtls_crl"
+	optionDomain = "mtls_domain"
+)
+
+var (
+	errNoCertificate  = errors.New("no certificate found")
+	errCRLSignature   = errors.New("crl is not signed by a certificate of the ca bundle")
+	errRevoked        = errors.New("client certificate revoked")
+	errNoEmailSubject = errors.New("client certificate has neither an email address nor a CN to map")
+)
+
+func init() {
+	pintu.RegisterAuthenticator("mtls", func(opts *pintu.OptionSet) pintu.Authenticator {
+		return NewMTLSProvider(opts)
+	})
+}
+
+// NewMTLSProvider creates the mtls instance declaring its options on opts
+func NewMTLSProvider(opts *pintu.OptionSet) *MTLSProvider {
+	s := &settings{}
+	opts.StringVar(&s.ca, optionCA, "", "pem bundle of the CAs signing the client certificates")
+	opts.StringVar(&s.crl, optionCRL, "", "pem or der certificate revocation list, optional")
+	opts.StringVar(&s.domain, optionDomain, "", "email domain appended to a CN which is not an email address")
+
+	return &MTLSProvider{
+		id:       opts.ID(),
+		name:     pintu.InstanceName("Client certificate", opts.ID()),
+		path:     pintu.InstancePath("/auth/mtls", opts.ID()),
+		settings: s,
+		options:  opts,
+	}
+}
+
+func (p *MTLSProvider) ParseSettings() error {
+	if err := p.options.Parse(); err != nil {
+		return err
+	}
+	option := p.options.Name(optionCA)
+	if p.settings.ca == "" {
+		return pintu.MissingParam(option)
+	}
+	cas, err := readCertificates(p.settings.ca)
+	if err != nil {
+		return pintu.InvalidParam(option, err)
+	}
+	p.roots = x509.NewCertPool()
+	for _, ca := range cas {
+		p.roots.AddCert(ca)
+	}
+
+	if p.settings.crl != "" {
+		if err := p.loadCRL(cas); err != nil {
+			return pintu.InvalidParam(p.options.Name(optionCRL), err)
+		}
+	}
+	return nil
+}
+
+func (p *MTLSProvider) ID() string {
+	return p.id
+}
+
+func (p *MTLSProvider) Name() string {
+	return p.name
+}
+
+func (p *MTLSProvider) Path() string {
+	return p.path
+}
+
+// ClientCertificates has the https listener request the client certificates
+func (p *MTLSProvider) ClientCertificates() bool {
+	return true
+}
+
+// AuthenticateRequest verifies the client certificate chain, the requests
+// without certificate are left to the other providers
+func (p *MTLSProvider) AuthenticateRequest(r *http.Request) (*pintu.Identity, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         p.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.revoked[revokedKey{issuer: string(leaf.RawIssuer), serial: leaf.SerialNumber.String()}] {
+		return nil, errRevoked
+	}
+
+	email, err := p.email(leaf)
+	if err != nil {
+		return nil, err
+	}
+	return &pintu.Identity{Email: email, Username: leaf.Subject.CommonName}, nil
+}
+
+// email maps the certificate to an identity, the SAN email first then the
+// CN, completed with the configured domain when it is a bare name
+func (p *MTLSProvider) email(cert *x509.Certificate) (string, error) {
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0], nil
+	}
+	cn := cert.Subject.CommonName
+	switch {
+	case strings.Contains(cn, "@"):
+		return cn, nil
+	case cn != "" && p.settings.domain != "":
+		return cn + "@" + p.settings.domain, nil
+	}
+	return "", errNoEmailSubject
+}
+
+// loadCRL reads the revoked serial numbers once the crl signature checks
+// against one of the CAs, they are keyed by the issuer of the crl
+func (p *MTLSProvider) loadCRL(cas []*x509.Certificate) error {
+	data, err := ioutil.ReadFile(p.settings.crl)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return err
+	}
+	signed := false
+	for _, ca := range cas {
+		if crl.CheckSignatureFrom(ca) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return errCRLSignature
+	}
+
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		pintu.Logger().Warn("crl is past its next update", "provider", p.name, "next_update", crl.NextUpdate)
+	}
+	p.revoked = make(map[revokedKey]bool)
+	for _, entry := range crl.RevokedCertificateEntries {
+		p.revoked[revokedKey{issuer: string(crl.RawIssuer), serial: entry.SerialNumber.String()}] = true
+	}
+	return nil
+}
+
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: %s", path, errNoCertificate.Error())
+	}
+	return certs, nil
+}
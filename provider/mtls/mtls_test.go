@@ -0,0 +1,124 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Tuxuri/pintu"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, email string) *x509.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(serial),
+		Subject:        pkix.Name{CommonName: email},
+		EmailAddresses: []string{email},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func (ca *testCA) revoke(t *testing.T, serials ...int64) []byte {
+	t.Helper()
+	list := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range serials {
+		list.RevokedCertificateEntries = append(list.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, list, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func TestRevokedByIssuer(t *testing.T) {
+	first, second := newTestCA(t, "first ca"), newTestCA(t, "second ca")
+	dir := t.TempDir()
+	var bundle []byte
+	for _, ca := range []*testCA{first, second} {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	}
+	caFile, crlFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "first.crl")
+	os.WriteFile(caFile, bundle, 0600)
+	os.WriteFile(crlFile, first.revoke(t, 7), 0600)
+
+	opts := pintu.NewOptionSet("")
+	p := NewMTLSProvider(opts)
+	opts.Set(optionCA, caFile)
+	opts.Set(optionCRL, crlFile)
+	if err := p.ParseSettings(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		revoked bool
+	}{
+		{"revoked serial of the crl issuer", first.issue(t, 7, "alice@example.com"), true},
+		{"other serial of the crl issuer", first.issue(t, 8, "bob@example.com"), false},
+		{"same serial of another ca", second.issue(t, 7, "carol@example.com"), false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.cert}}
+		identity, err := p.AuthenticateRequest(r)
+		if test.revoked && err != errRevoked {
+			t.Errorf("%s: AuthenticateRequest() = %v, %v, want revoked", test.name, identity, err)
+		}
+		if !test.revoked && (err != nil || identity == nil || identity.Email != test.cert.EmailAddresses[0]) {
+			t.Errorf("%s: AuthenticateRequest() = %v, %v", test.name, identity, err)
+		}
+	}
+}
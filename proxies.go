@@ -0,0 +1,100 @@
+package pintu
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the proxies in front of pintu whose X-Forwarded-For and
+// X-Real-IP headers are believed, unix trusts the unix socket peers
+type trustedProxies struct {
+	nets []*net.IPNet
+	unix bool
+}
+
+const trustUnix = "unix"
+
+var errTrustedProxy = errors.New("requires ips, cidrs or unix")
+
+func parseTrustedProxies(specs []string) (*trustedProxies, error) {
+	t := &trustedProxies{}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		switch {
+		case spec == trustUnix:
+			t.unix = true
+			continue
+		case !strings.Contains(spec, "/") && strings.Contains(spec, ":"):
+			spec += "/128"
+		case !strings.Contains(spec, "/"):
+			spec += "/32"
+		}
+		_, network, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, errTrustedProxy
+		}
+		t.nets = append(t.nets, network)
+	}
+	return t, nil
+}
+
+// trusted tells whether the peer or hop at addr is a trusted proxy, the unix
+// socket peers have no address
+func (t *trustedProxies) trusted(addr string) bool {
+	if t == nil {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return t.unix && (addr == "" || addr == "@")
+	}
+	for _, network := range t.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP walks the forwarded hops back from the peer and returns the
+// first one which is not a trusted proxy, a hop that does not parse stops
+// the walk at the proxy which added it
+func (t *trustedProxies) clientIP(req *http.Request) string {
+	ip := peerIP(req)
+	if !t.trusted(ip) {
+		return ip
+	}
+	var hops []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		hops = []string{req.Header.Get("X-Real-IP")}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop.String()
+		if !t.trusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// peerIP returns the address of the connection peer, empty or @ for the
+// unix sockets
+func peerIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
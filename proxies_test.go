@@ -0,0 +1,49 @@
+package pintu
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::1", "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, peer, forwarded, realIP, ip string
+	}{
+		{"direct", "192.0.2.1:1234", "", "", "192.0.2.1"},
+		{"direct ipv6", "[2001:db8::2]:1234", "", "", "2001:db8::2"},
+		{"untrusted peer headers", "192.0.2.1:1234", "198.51.100.1", "198.51.100.2", "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted ipv6 proxy", "[2001:db8::1]:443", "2001:db8:ffff::5", "", "2001:db8:ffff::5"},
+		{"proxy chain", "10.0.0.1:1234", "203.0.113.9, 198.51.100.1, 10.0.0.2", "", "198.51.100.1"},
+		{"spoofed first hop", "10.0.0.1:1234", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"real ip", "10.0.0.1:1234", "", "198.51.100.1", "198.51.100.1"},
+		{"forwarded wins over real ip", "10.0.0.1:1234", "198.51.100.1", "198.51.100.2", "198.51.100.1"},
+		{"garbage hop", "10.0.0.1:1234", "198.51.100.1, junk", "", "10.0.0.1"},
+		{"unix socket", "@", "198.51.100.1", "", "198.51.100.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.peer
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+		if ip := proxies.clientIP(r); ip != test.ip {
+			t.Errorf("%s: clientIP() = %q, want %q", test.name, ip, test.ip)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, spec := range []string{"10.0.0.0/33", "proxy.example.com", "unix:/run/pintu.sock"} {
+		if _, err := parseTrustedProxies([]string{spec}); err == nil {
+			t.Errorf("parseTrustedProxies(%q) accepted", spec)
+		}
+	}
+}
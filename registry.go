@@ -0,0 +1,216 @@
+package pintu
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProviderFactory builds a fresh provider instance which declares its options
+// on opts, opts.ID() is empty for the default instance of the type
+type ProviderFactory func(opts *OptionSet) Provider
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]ProviderFactory)
+
+	// providerID keeps the ids usable in option, environment and route names
+	providerID = regexp.MustCompile(`^[a-z0-9-]+$`)
+)
+
+// RegisterProvider makes a provider type available by name, it is meant to
+// be called from the init function of the provider package
+func RegisterProvider(ptype string, factory ProviderFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("pintu: RegisterProvider factory is nil")
+	}
+	if _, dup := registry[ptype]; dup {
+		panic("pintu: RegisterProvider called twice for provider " + ptype)
+	}
+	registry[ptype] = factory
+}
+
+// NewProvider builds an instance of the registered provider type, its
+// options are declared on opts
+func NewProvider(ptype string, opts *OptionSet) (Provider, error) {
+	factory, err := lookupProvider(ptype)
+	if err != nil {
+		return nil, err
+	}
+	return factory(opts), nil
+}
+
+func lookupProvider(ptype string) (ProviderFactory, error) {
+	registryMu.RLock()
+	factory, ok := registry[ptype]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider type %q, available types are %v", ptype, ProviderTypes())
+	}
+	return factory, nil
+}
+
+// ProviderTypes returns the sorted list of registered provider types
+func ProviderTypes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]string, 0, len(registry))
+	for ptype := range registry {
+		types = append(types, ptype)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ParseProviderSpec splits a "<type>" or "<type>:<id>" provider spec, the
+// ids are made of lowercase letters, digits and dashes
+func ParseProviderSpec(spec string) (ptype, id string, err error) {
+	parts := strings.SplitN(spec, ":", 2)
+	ptype = parts[0]
+	if len(parts) == 2 {
+		id = parts[1]
+		if !providerID.MatchString(id) {
+			return "", "", fmt.Errorf("invalid provider id in %q, ids are made of lowercase letters, digits and -", spec)
+		}
+	}
+	if ptype == "" {
+		return "", "", fmt.Errorf("invalid provider spec %q", spec)
+	}
+	return ptype, id, nil
+}
+
+// InstanceOption namespaces an option name for a named provider instance,
+// ie ldap_server becomes corp_ldap_server for the instance ldap:corp
+func InstanceOption(id, option string) string {
+	if id == "" {
+		return option
+	}
+	return id + "_" + option
+}
+
+// InstancePath builds the route prefix of a provider instance, the default
+// instance keeps the historical /auth/<type> routes
+func InstancePath(prefix, id string) string {
+	if id == "" {
+		return prefix
+	}
+	return prefix + "/" + id
+}
+
+// InstanceName labels a named provider instance on the login page
+func InstanceName(name, id string) string {
+	if id == "" {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, id)
+}
+
+// providerSpecs looks up the enabled providers ahead of the flags parsing,
+// the instances have to exist before their flags can be parsed
+// Settings priority CLI > Options > ENV > config file
+func (o Options) providerSpecs(config *Config) StringSlice {
+	var specs StringSlice
+	if o.FlagSet != nil {
+		for _, value := range lookupArgs(o.Args, optionProviders) {
+			specs.Set(value)
+		}
+	}
+	if len(specs) == 0 {
+		specs = o.Providers
+	}
+	if len(specs) == 0 {
+		EnvStringSliceVar(&specs, optionProviders)
+	}
+	if len(specs) == 0 {
+		specs = config.ProviderSpecs()
+	}
+	return specs
+}
+
+// lookupArgs collects the values of a non boolean flag from raw arguments
+// following the flag package syntax
+func lookupArgs(args []string, name string) []string {
+	var values []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			continue
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if arg == name && i+1 < len(args) {
+			values = append(values, args[i+1])
+			i++
+		} else if strings.HasPrefix(arg, name+"=") {
+			values = append(values, arg[len(name)+1:])
+		}
+	}
+	return values
+}
+
+// SessionStoreFactory opens the session store of a session_store url
+type SessionStoreFactory func(u *url.URL) (SessionStore, error)
+
+const memoryStore = "memory"
+
+var storeRegistry = make(map[string]SessionStoreFactory)
+
+// RegisterSessionStore makes a session store available by url scheme, it is
+// meant to be called from the init function of the store package
+func RegisterSessionStore(scheme string, factory SessionStoreFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("pintu: RegisterSessionStore factory is nil")
+	}
+	if _, dup := storeRegistry[scheme]; dup || scheme == memoryStore {
+		panic("pintu: RegisterSessionStore called twice for scheme " + scheme)
+	}
+	storeRegistry[scheme] = factory
+}
+
+// OpenSessionStore opens the store of a session_store value, memory or
+// empty for the in memory store
+func OpenSessionStore(spec string) (SessionStore, error) {
+	if spec == "" || spec == memoryStore {
+		return NewMemoryStore(), nil
+	}
+	u, factory, err := lookupSessionStore(spec)
+	if err != nil {
+		return nil, err
+	}
+	return factory(u)
+}
+
+func lookupSessionStore(spec string) (*url.URL, SessionStoreFactory, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme == "" {
+		return nil, nil, fmt.Errorf("requires memory or a store url, available schemes are %v", SessionStoreSchemes())
+	}
+	registryMu.RLock()
+	factory, ok := storeRegistry[u.Scheme]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown session store %q, available schemes are %v", u.Scheme, SessionStoreSchemes())
+	}
+	return u, factory, nil
+}
+
+// SessionStoreSchemes returns the sorted list of registered store schemes
+func SessionStoreSchemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	schemes := make([]string, 0, len(storeRegistry))
+	for scheme := range storeRegistry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
@@ -0,0 +1,35 @@
+package pintu
+
+import "testing"
+
+func TestParseProviderSpec(t *testing.T) {
+	tests := []struct {
+		spec, ptype, id string
+		valid           bool
+	}{
+		{"ldap", "ldap", "", true},
+		{"ldap:corp", "ldap", "corp", true},
+		{"ldap:corp-eu-2", "ldap", "corp-eu-2", true},
+		{"ldap:", "", "", false},
+		{":corp", "", "", false},
+		{"ldap:Corp", "", "", false},
+		{"ldap:corp_eu", "", "", false},
+		{"ldap:corp/eu", "", "", false},
+		{"ldap:corp eu", "", "", false},
+		{"ldap:corp:eu", "", "", false},
+		{"ldap:../x", "", "", false},
+		{"ldap:corp\n", "", "", false},
+	}
+	for _, test := range tests {
+		ptype, id, err := ParseProviderSpec(test.spec)
+		if (err == nil) != test.valid || ptype != test.ptype || id != test.id {
+			t.Errorf("ParseProviderSpec(%q) = %q, %q, %v", test.spec, ptype, id, err)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if name := EnvName(InstanceOption("corp-eu", "ldap_server")); name != "PINTU_CORP_EU_LDAP_SERVER" {
+		t.Fatalf("EnvName() = %s", name)
+	}
+}
@@ -0,0 +1,156 @@
+package pintu
+
+import (
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const (
+	adminReloadPath    = "/reload"
+	adminUpstreamsPath = "/upstreams"
+	adminVarsPath      = "/debug/vars"
+	adminSessionsPath  = "/sessions"
+	adminRevokePath    = "/sessions/revoke"
+	adminMFAResetPath  = "/mfa/reset"
+)
+
+// Reload reads the config file, the environment and the command line again
+// and rebuilds the providers, rules and upstream routing, an invalid
+// configuration is rejected and the running one kept
+func (p *Pintu) Reload() error {
+	return p.reload(nil)
+}
+
+// reload audits the reload asked by r, nil for the signals
+func (p *Pintu) reload(r *http.Request) error {
+	options := p.options
+	if options.FlagSet != nil {
+		// the flags of the new provider instances need a fresh set
+		options.FlagSet = flag.NewFlagSet(options.FlagSet.Name(), flag.ContinueOnError)
+		options.FlagSet.SetOutput(ioutil.Discard)
+	}
+	if err := p.load(options); err != nil {
+		Logger().Error("reload failed, keeping the running configuration", "err", err.Error())
+		p.runtime().audit.emit(r, AuditEvent{Type: AuditConfigReload, Reason: err.Error()})
+		return err
+	}
+	Logger().Info("configuration reloaded")
+	p.runtime().audit.emit(r, AuditEvent{Type: AuditConfigReload})
+	return nil
+}
+
+func (p *Pintu) reloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		p.Reload()
+	}
+}
+
+// adminHandler serves the admin endpoints, it is meant for an internal
+// listener as it does not go through the Guard
+func (p *Pintu) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminReloadPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := p.reload(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Write([]byte("configuration reloaded\n"))
+	})
+	mux.HandleFunc(adminUpstreamsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.runtime().settings.routes.Status())
+	})
+	mux.Handle(adminVarsPath, expvar.Handler())
+	mux.HandleFunc(adminSessionsPath, func(w http.ResponseWriter, r *http.Request) {
+		sessions, err := p.sessions.List(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+	})
+	mux.HandleFunc(adminRevokePath, p.revokeHandler)
+	mux.HandleFunc(adminMFAResetPath, p.mfaResetHandler)
+	mux.HandleFunc(pingPath, p.servePing)
+	mux.HandleFunc(readyPath, func(w http.ResponseWriter, r *http.Request) {
+		p.serveReady(w, r, true)
+	})
+	mux.HandleFunc(versionPath, p.serveVersion)
+	return mux
+}
+
+// revokeHandler deletes the session given by id or every session of email
+func (p *Pintu) revokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, email := r.FormValue("id"), r.FormValue("email")
+	revoked := 0
+	var err error
+	switch {
+	case id != "":
+		var session *Session
+		if session, err = p.sessions.Load(r.Context(), id); err == nil {
+			err = p.sessions.Delete(r.Context(), id)
+			revoked, email = 1, session.Email
+		}
+	case email != "":
+		revoked, err = RevokeSessions(r.Context(), p.sessions, email)
+	default:
+		http.Error(w, "id or email required", http.StatusBadRequest)
+		return
+	}
+	if err != nil && err != ErrSessionNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	RequestLogger(r).Info("sessions revoked", "count", revoked, "id", id != "", "email", email)
+	if revoked > 0 {
+		p.runtime().audit.emit(r, AuditEvent{Type: AuditSessionRevoked, User: email, Reason: "admin"})
+	}
+	fmt.Fprintf(w, "%d sessions revoked\n", revoked)
+}
+
+// mfaResetHandler deletes the second factor of email, the user enrolls
+// again on the next login
+func (p *Pintu) mfaResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rt := p.runtime()
+	if rt.mfa == nil {
+		http.Error(w, "second factor disabled", http.StatusNotFound)
+		return
+	}
+	email := r.FormValue("email")
+	if email == "" {
+		http.Error(w, "email required", http.StatusBadRequest)
+		return
+	}
+	if err := rt.mfa.store.DeleteSecret(r.Context(), email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	RequestLogger(r).Info("second factor reset", "email", email)
+	rt.audit.emit(r, AuditEvent{Type: AuditMFAReset, User: email, Reason: "admin"})
+	fmt.Fprintf(w, "second factor of %s reset\n", email)
+}
@@ -0,0 +1,54 @@
+package pintu
+
+import (
+	"flag"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestReloadWithFlagSet(t *testing.T) {
+	path := writeConfig(t, "pintu.yaml", "rules:\n  - path: /admin0\n    emails: [alice@example.com]\n")
+	fs := flag.NewFlagSet("pintud", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	p := NewPintu(Options{
+		FlagSet: fs,
+		Args: []string{
+			"--config=" + path,
+			"--providers=stub:corp",
+			"--corp_stub_realm=corp",
+			"--upstream=http://127.0.0.1:8080",
+			"--cookie_secret=not so secret",
+		},
+	})
+	if err := p.load(p.options); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { p.runtime().settings.routes.Close() }()
+
+	tests := []struct {
+		config, rule string
+		valid        bool
+	}{
+		{"rules:\n  - path: /admin1\n    emails: [alice@example.com]\n", "/admin1", true},
+		{"rules:\n  - path: /admin2\n    emails: [alice@example.com]\n", "/admin2", true},
+		{"rules:\n  - path: admin3\n    emails: [alice@example.com]\n", "/admin2", false},
+		{"rules:\n  - path: /admin4\n    emails: [alice@example.com]\n", "/admin4", true},
+		{"rules:\n  - path: /admin4\n    emails: [alice@example.com]\n", "/admin4", true},
+	}
+	for i, test := range tests {
+		if err := os.WriteFile(path, []byte(test.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Reload(); (err == nil) != test.valid {
+			t.Fatalf("reload %d: Reload() = %v", i, err)
+		}
+		rt := p.runtime()
+		if rules := rt.settings.Rules; len(rules) != 1 || rules[0].Path != test.rule {
+			t.Fatalf("reload %d: rules %+v, want %s", i, rules, test.rule)
+		}
+		if rt.settings.CookieSecret != "not so secret" {
+			t.Fatalf("reload %d: the command line options were lost", i)
+		}
+	}
+}
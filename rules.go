@@ -0,0 +1,100 @@
+package pintu
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	// Rule restricts the paths under Path to the listed users, an email
+	// matches either one of Emails or one of Domains, MFA asks for the second
+	// factor and alone leaves the paths open to any user giving it
+	Rule struct {
+		Path    string   `json:"path"`
+		Emails  []string `json:"emails"`
+		Domains []string `json:"domains"`
+		MFA     bool     `json:"mfa"`
+	}
+
+	// Rules are the authorization rules, the rule with the longest matching
+	// path applies and paths without rule are open to any logged in user
+	Rules []Rule
+)
+
+var (
+	errRulePath  = errors.New("path must start with /")
+	errRuleUsers = errors.New("requires emails, domains or mfa")
+)
+
+// Allow tells whether email may access path
+func (rs Rules) Allow(path, email string) bool {
+	rule := rs.Match(path)
+	if rule == nil {
+		return true
+	}
+	return rule.Allow(email)
+}
+
+// RequireMFA tells whether path needs the second factor
+func (rs Rules) RequireMFA(path string) bool {
+	rule := rs.Match(path)
+	return rule != nil && rule.MFA
+}
+
+// mfa tells whether any rule needs the second factor
+func (rs Rules) mfa() bool {
+	for _, rule := range rs {
+		if rule.MFA {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the rule applying to path, nil when there is none
+func (rs Rules) Match(path string) *Rule {
+	var match *Rule
+	for i := range rs {
+		rule := &rs[i]
+		if !underPath(path, rule.Path) {
+			continue
+		}
+		if match == nil || len(rule.Path) > len(match.Path) {
+			match = rule
+		}
+	}
+	return match
+}
+
+// Allow tells whether email is listed by the rule
+func (r *Rule) Allow(email string) bool {
+	if len(r.Emails) == 0 && len(r.Domains) == 0 {
+		return true
+	}
+	for _, allowed := range r.Emails {
+		if strings.EqualFold(allowed, email) {
+			return true
+		}
+	}
+	for _, domain := range r.Domains {
+		if strings.HasSuffix(strings.ToLower(email), "@"+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs Rules) validate() error {
+	var errs ValidationErrors
+	for i, rule := range rs {
+		option := fmt.Sprintf("%s[%d]", configRules, i)
+		if !strings.HasPrefix(rule.Path, "/") {
+			errs.Add(InvalidParam(option, errRulePath))
+		}
+		if len(rule.Emails) == 0 && len(rule.Domains) == 0 && !rule.MFA {
+			errs.Add(InvalidParam(option, errRuleUsers))
+		}
+	}
+	return errs.Err()
+}
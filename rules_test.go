@@ -0,0 +1,37 @@
+package pintu
+
+import "testing"
+
+func TestRulesMatch(t *testing.T) {
+	rules := Rules{
+		{Path: "/", Domains: []string{"example.com"}},
+		{Path: "/admin", Emails: []string{"alice@example.com"}},
+		{Path: "/admin/billing/", Emails: []string{"bob@example.com"}},
+	}
+	if err := rules.validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, rule string
+	}{
+		{"/", "/"},
+		{"/admin", "/admin"},
+		{"/admin/", "/admin"},
+		{"/admin/users", "/admin"},
+		{"/administrator", "/"},
+		{"/admin-panel/", "/"},
+		{"/admin/billing", "/admin"},
+		{"/admin/billing/", "/admin/billing/"},
+		{"/admin/billing/invoices", "/admin/billing/"},
+		{"/admin/billingx", "/admin"},
+	}
+	for _, test := range tests {
+		rule := rules.Match(test.path)
+		if rule == nil || rule.Path != test.rule {
+			t.Errorf("Match(%s) = %v, want the rule of %s", test.path, rule, test.rule)
+		}
+	}
+	if !rules.Allow("/administrator", "carol@example.com") || rules.Allow("/admin/users", "carol@example.com") {
+		t.Fatal("Allow() applied the /admin rule across a segment")
+	}
+}
@@ -0,0 +1,77 @@
+package pintu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+type (
+	// SecretResolver looks up the secret a reference points to, references
+	// are written <scheme>:<ref> in the config file, ie file:/run/secrets/x
+	SecretResolver interface {
+		Resolve(ref string) (string, error)
+	}
+
+	// SecretResolverFunc adapts a function to SecretResolver
+	SecretResolverFunc func(ref string) (string, error)
+)
+
+var (
+	secretResolversMu sync.RWMutex
+	secretResolvers   = map[string]SecretResolver{
+		"file": SecretResolverFunc(readSecretFile),
+		"env":  SecretResolverFunc(lookupSecretEnv),
+	}
+)
+
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// RegisterSecretResolver makes the secret references of scheme available,
+// ie a vault or cloud secret manager client
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+	if resolver == nil {
+		panic("pintu: RegisterSecretResolver resolver is nil")
+	}
+	secretResolvers[scheme] = resolver
+}
+
+// ResolveSecret returns the secret referenced by value, values without a
+// registered scheme are returned as is
+func ResolveSecret(value string) (string, error) {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return value, nil
+	}
+	secretResolversMu.RLock()
+	resolver, ok := secretResolvers[value[:i]]
+	secretResolversMu.RUnlock()
+	if !ok {
+		return value, nil
+	}
+	return resolver.Resolve(value[i+1:])
+}
+
+// readSecretFile reads a docker or kubernetes secret, the trailing newline
+// left by editors is dropped
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func lookupSecretEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,176 @@
+package pintu
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Session is a logged in visitor, the cookie carries its id, Pending
+	// sessions only reach the second factor pages and MFA ones gave it
+	Session struct {
+		ID        string    `json:"id"`
+		Email     string    `json:"email"`
+		Provider  string    `json:"provider"`
+		CreatedAt time.Time `json:"created_at"`
+		ExpiresAt time.Time `json:"expires_at"`
+		MFA       bool      `json:"mfa,omitempty"`
+		Pending   bool      `json:"pending,omitempty"`
+	}
+
+	// SessionStore keeps the sessions so they can be listed and revoked
+	// ahead of their expiry, it outlives the reloads
+	SessionStore interface {
+		Save(ctx context.Context, session *Session) error
+		// Load returns ErrSessionNotFound for unknown and expired sessions
+		Load(ctx context.Context, id string) (*Session, error)
+		Delete(ctx context.Context, id string) error
+		List(ctx context.Context) ([]*Session, error)
+		Close() error
+	}
+
+	// SessionCounter is optionally implemented by the session stores which
+	// count the live sessions without loading them
+	SessionCounter interface {
+		CountSessions(ctx context.Context) (int, error)
+	}
+
+	// MemoryStore is the default SessionStore, the sessions and the login
+	// attempts are lost on restart and not shared between replicas
+	MemoryStore struct {
+		mu       sync.RWMutex
+		sessions map[string]Session
+		attempts map[string]attemptRecord
+	}
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// NewSession creates a session for email expiring after expiry
+func NewSession(email, provider string, expiry time.Duration) (*Session, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Session{
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		Email:     email,
+		Provider:  provider,
+		CreatedAt: now,
+		ExpiresAt: now.Add(expiry),
+	}, nil
+}
+
+// Expired tells whether the session is past its expiry
+func (s *Session) Expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]Session),
+		attempts: make(map[string]attemptRecord),
+	}
+}
+
+// Save stores the session and drops the expired ones
+func (m *MemoryStore) Save(ctx context.Context, session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, s := range m.sessions {
+		if s.Expired(now) {
+			delete(m.sessions, id)
+		}
+	}
+	m.sessions[session.ID] = *session
+	return nil
+}
+
+func (m *MemoryStore) Load(ctx context.Context, id string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok || s.Expired(time.Now()) {
+		return nil, ErrSessionNotFound
+	}
+	return &s, nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// List returns the live sessions, oldest first
+func (m *MemoryStore) List(ctx context.Context) ([]*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		if !s.Expired(now) {
+			s := s
+			sessions = append(sessions, &s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (m *MemoryStore) CountSessions(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	count := 0
+	for _, s := range m.sessions {
+		if !s.Expired(now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// CountSessions counts the live sessions of store, listing them when it is
+// not a SessionCounter
+func CountSessions(ctx context.Context, store SessionStore) (int, error) {
+	if counter, ok := store.(SessionCounter); ok {
+		return counter.CountSessions(ctx)
+	}
+	sessions, err := store.List(ctx)
+	return len(sessions), err
+}
+
+// RevokeSessions deletes the sessions of email, it returns how many were
+// revoked
+func RevokeSessions(ctx context.Context, store SessionStore, email string) (int, error) {
+	sessions, err := store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, s := range sessions {
+		if s.Email != email {
+			continue
+		}
+		if err := store.Delete(ctx, s.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
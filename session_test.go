@@ -0,0 +1,28 @@
+package pintu
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// listOnlyStore hides the SessionCounter of its store
+type listOnlyStore struct {
+	SessionStore
+}
+
+func TestCountSessions(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	live, _ := NewSession("alice@example.com", "htpasswd", time.Hour)
+	expired, _ := NewSession("bob@example.com", "htpasswd", time.Hour)
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	for _, session := range []*Session{live, expired} {
+		store.sessions[session.ID] = *session
+	}
+	for _, s := range []SessionStore{store, listOnlyStore{store}} {
+		if count, err := CountSessions(ctx, s); err != nil || count != 1 {
+			t.Errorf("CountSessions(%T) = %d, %v, want 1", s, count, err)
+		}
+	}
+}
@@ -1,17 +1,17 @@
 package pintu
 
 import (
-	"crypto/sha1"
+	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
-	"flag"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
-	"time"
 )
 
 type (
@@ -23,36 +23,154 @@ type (
 		CookieKey    string
 		CookieSecret string
 		CookieExpiry int64
+		Providers    StringSlice
+		ConfigFile   string
+		// Headers are added to every upstream request
+		Headers map[string]string
+		Rules   Rules
+		// Upstreams route by host and path, Upstream is their catch-all
+		Upstreams Upstreams
+		routes    Upstreams
+		// AdminAddress serves the admin endpoints, disabled when empty
+		AdminAddress string
+		// MetricsAddress serves the prometheus metrics, disabled when empty
+		MetricsAddress string
+		// TLSCert and TLSKey switch the http listener to https
+		TLSCert             string
+		TLSKey              string
+		TLSMinVersion       string
+		TLSCipherSuites     StringSlice
+		HTTPRedirectAddress string
+		tlsConfig           *tls.Config
+		// StreamCheckInterval closes the websockets and event streams of
+		// the expired or revoked sessions, in seconds
+		StreamCheckInterval int64
+		// the listeners timeouts and the graceful shutdown, in seconds
+		ReadTimeout     int64
+		WriteTimeout    int64
+		IdleTimeout     int64
+		ShutdownTimeout int64
+		ShutdownDelay   int64
+		LogFormat       string
+		LogLevel        string
+		logger          *slog.Logger
+		// Audit lists the audit sinks, reopened on reload
+		Audit StringSlice
+		// OTLPEndpoint receives the spans, tracing is off when empty
+		OTLPEndpoint       string
+		TraceSamplePercent int64
+		// LoginMaxFailures locks a username out for LoginLockout seconds,
+		// LoginMaxFailuresIP a client ip, 0 disables
+		LoginMaxFailures   int64
+		LoginMaxFailuresIP int64
+		LoginBackoff       int64
+		LoginLockout       int64
+		// HealthPrefix moves /ping, /ready and /version off the upstream paths
+		HealthPrefix string
+		// TrustedProxies may set the client ip with X-Forwarded-For or
+		// X-Real-IP, the peer address is the client ip otherwise
+		TrustedProxies StringSlice
+		trustedProxies *trustedProxies
+		// MFA is optional or required, MFASecrets the file of the TOTP secrets
+		MFA        string
+		MFASecrets string
+		MFAIssuer  string
+		// SessionStore keeps the sessions and the login attempts, memory or
+		// the url of a registered store shared by the replicas
+		SessionStore string
+		// SocketMode is the permission of the unix socket listeners
+		SocketMode string
+		socketMode os.FileMode
+
+		options         *OptionSet
+		generatedSecret bool
 	}
 
 	StringSlice []string
 )
 
+var (
+	errRequiresInteger = errors.New("requires integer character")
+	errUnknownOption   = errors.New("unknown option")
+	errEmptyHeader     = errors.New("header name is empty")
+	errNegative        = errors.New("must not be negative")
+	errHealthPrefix    = errors.New("must start with / and not end with one")
+)
+
 const (
-	optionHTTPAddress  = "http"
-	optionUpstream     = "upstream"
-	optionCookieKey    = "cookie_key"
-	optionCookieSecret = "cookie_secret"
-	optionCookieExpiry = "cookie_expiry"
+	optionConfig         = "config"
+	optionHTTPAddress    = "http"
+	optionUpstream       = "upstream"
+	optionCookieKey      = "cookie_key"
+	optionCookieSecret   = "cookie_secret"
+	optionCookieExpiry   = "cookie_expiry"
+	optionProviders      = "providers"
+	optionAdminAddress   = "admin_http"
+	optionMetricsAddress = "metrics_http"
+
+	optionTLSCert         = "tls_cert"
+	optionTLSKey          = "tls_key"
+	optionTLSMinVersion   = "tls_min_version"
+	optionTLSCipherSuites = "tls_cipher_suites"
+	optionHTTPRedirect    = "http_redirect"
+	optionStreamCheck     = "stream_check_interval"
+	optionReadTimeout     = "read_timeout"
+	optionWriteTimeout    = "write_timeout"
+	optionIdleTimeout     = "idle_timeout"
+	optionShutdownTimeout = "shutdown_timeout"
+	optionShutdownDelay   = "shutdown_delay"
+	optionSocketMode      = "socket_mode"
+	optionLogFormat       = "log_format"
+	optionLogLevel        = "log_level"
+	optionAudit           = "audit"
+	optionOTLPEndpoint    = "otlp_endpoint"
+	optionTraceSample     = "trace_sample_percent"
+	optionHealthPrefix    = "health_prefix"
+	optionTrustedProxies  = "trusted_proxies"
+	optionLoginMaxFailure = "login_max_failures"
+	optionLoginMaxIP      = "login_max_failures_per_ip"
+	optionLoginBackoff    = "login_backoff"
+	optionLoginLockout    = "login_lockout"
+	optionMFA             = "mfa"
+	optionMFASecrets      = "mfa_secrets"
+	optionMFAIssuer       = "mfa_issuer"
+	optionSessionStore    = "session_store"
 
 	defaultHTTPAddress            = "127.0.0.1:4180"
 	defaultUpstream               = ""
 	defaultCookieKey              = "_pintu"
-	defaultCookieSecret           = "randomly generated sha1 hash"
 	defaultCookieExpiryHour int64 = 168 // 7 days
+	defaultTLSMinVersion          = "1.2"
+	defaultIdleTimeout      int64 = 120
+	defaultShutdownTimeout  int64 = 30
+	defaultSocketMode             = "0660"
+	defaultLogFormat              = "logfmt"
+	defaultLogLevel               = "info"
+	defaultSamplePercent    int64 = 100
+	defaultLoginMaxFailures int64 = 5
+	defaultLoginMaxIP       int64 = 20
+	defaultLoginBackoff     int64 = 1
+	defaultLoginLockout     int64 = 900
+	defaultMFAIssuer              = "pintu"
 )
 
+// Set appends the comma separated values, the flag may also be repeated
 func (l *StringSlice) Set(s string) error {
-	*l = append(*l, s)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			*l = append(*l, item)
+		}
+	}
 	return nil
 }
 
 func (l *StringSlice) String() string {
-	return fmt.Sprint(*l)
+	return strings.Join(*l, ",")
 }
 
 func EnvStringSliceVar(ss *StringSlice, field string) {
-	values := os.Getenv(field)
+	values, _ := LookupEnv(field)
 	if values != "" {
 		var l StringSlice
 		for _, item := range strings.Split(values, ",") {
@@ -67,56 +185,88 @@ func EnvStringSliceVar(ss *StringSlice, field string) {
 	}
 }
 
-// Warning, settings error is not tolerated, all the invalid input will result in os.Exit
-
-// GetSettings returns settings from cli and env
-// Settings priority CLI > ENV
-func GetSettings() Settings {
-	cli := cliSettings()
-	env := envSettings()
-	s := merge(cli, env)
-	s.validateSettings()
+// NewSettings declares the pintu options on opts, the values are resolved
+// by Parse once the command line is parsed
+func NewSettings(opts *OptionSet) *Settings {
+	s := &Settings{options: opts}
+	opts.StringVar(&s.ConfigFile, optionConfig, "", "config file path, json, yaml or toml according to the extension")
+	opts.StringVar(&s.HTTPAddress, optionHTTPAddress, defaultHTTPAddress, "<addr>:<port> or unix:///path/to/pintu.sock to listen on for HTTP clients")
+	opts.StringVar(&s.Upstream, optionUpstream, defaultUpstream, "the http url of the upstream endpoint")
+	opts.StringVar(&s.CookieKey, optionCookieKey, defaultCookieKey, "the name of the secure cookies")
+	opts.SecretVar(&s.CookieSecret, optionCookieSecret, "the seed string for secure cookies, randomly generated when empty, required with a shared session_store")
+	opts.Int64Var(&s.CookieExpiry, optionCookieExpiry, defaultCookieExpiryHour, "cookie lifespan in hour")
+	opts.StringVar(&s.AdminAddress, optionAdminAddress, "", "<addr>:<port> to listen on for the admin endpoints, disabled when empty")
+	opts.StringVar(&s.MetricsAddress, optionMetricsAddress, "", "<addr>:<port> to listen on for the prometheus /metrics, disabled when empty")
+	opts.StringVar(&s.TLSCert, optionTLSCert, "", "tls certificate file, serves https when set, reloaded on change")
+	opts.StringVar(&s.TLSKey, optionTLSKey, "", "tls private key file")
+	opts.StringVar(&s.TLSMinVersion, optionTLSMinVersion, defaultTLSMinVersion, "minimum tls version, 1.0, 1.1, 1.2 or 1.3")
+	opts.Var(&s.TLSCipherSuites, optionTLSCipherSuites, "comma separated tls 1.2 cipher suites, go defaults when empty")
+	opts.StringVar(&s.HTTPRedirectAddress, optionHTTPRedirect, "", "<addr>:<port> to redirect plain http clients to https, disabled when empty")
+	opts.Int64Var(&s.StreamCheckInterval, optionStreamCheck, 0, "seconds between the session checks of websockets and event streams, closed once the session expires or is revoked, 0 disables")
+	opts.Int64Var(&s.ReadTimeout, optionReadTimeout, 0, "seconds to read a request including its body, 0 disables")
+	opts.Int64Var(&s.WriteTimeout, optionWriteTimeout, 0, "seconds to write a response, 0 disables, it would cut the streams")
+	opts.Int64Var(&s.IdleTimeout, optionIdleTimeout, defaultIdleTimeout, "seconds a keep-alive connection waits for the next request")
+	opts.Int64Var(&s.ShutdownTimeout, optionShutdownTimeout, defaultShutdownTimeout, "seconds to drain the requests in flight on shutdown")
+	opts.Int64Var(&s.ShutdownDelay, optionShutdownDelay, 0, "seconds to fail readiness before the listeners stop accepting on shutdown")
+	opts.StringVar(&s.SocketMode, optionSocketMode, defaultSocketMode, "octal permission of the unix socket listeners")
+	opts.StringVar(&s.LogFormat, optionLogFormat, defaultLogFormat, "log format, logfmt or json")
+	opts.StringVar(&s.LogLevel, optionLogLevel, defaultLogLevel, "minimum log level, debug, info, warn or error")
+	opts.Var(&s.Audit, optionAudit, "comma separated audit sinks, file:/path, syslog:, syslog+udp://host:port, syslog+tcp://host:port or a http(s) webhook url")
+	opts.StringVar(&s.OTLPEndpoint, optionOTLPEndpoint, "", "otlp http collector url receiving the traces, like http://localhost:4318, disabled when empty")
+	opts.Int64Var(&s.TraceSamplePercent, optionTraceSample, defaultSamplePercent, "percent of the new traces sampled, the incoming traceparent decision is followed")
+	opts.Var(&s.TrustedProxies, optionTrustedProxies, "comma separated ips or cidrs of the proxies allowed to set the client ip with X-Forwarded-For or X-Real-IP, unix for the unix socket peers")
+	opts.StringVar(&s.HealthPrefix, optionHealthPrefix, defaultHealthPrefix, "path prefix of the /ping, /ready and /version endpoints on the http listener, empty serves them at the root")
+	opts.Int64Var(&s.LoginMaxFailures, optionLoginMaxFailure, defaultLoginMaxFailures, "failed passwords locking a username out, 0 disables")
+	opts.Int64Var(&s.LoginMaxFailuresIP, optionLoginMaxIP, defaultLoginMaxIP, "failed passwords locking a client ip out, 0 disables")
+	opts.Int64Var(&s.LoginBackoff, optionLoginBackoff, defaultLoginBackoff, "seconds a username waits after its second failure, doubled on every further one")
+	opts.Int64Var(&s.LoginLockout, optionLoginLockout, defaultLoginLockout, "seconds of lockout, the failures are forgotten as long after the last one")
+	opts.StringVar(&s.MFA, optionMFA, mfaOptional, "second factor, optional for the enrolled users or required from every user, required can not be combined with the request providers like mtls")
+	opts.StringVar(&s.MFASecrets, optionMFASecrets, "", "json file of the TOTP secrets, enables the second factor")
+	opts.StringVar(&s.MFAIssuer, optionMFAIssuer, defaultMFAIssuer, "issuer shown by the authenticator apps")
+	opts.SecretVar(&s.SessionStore, optionSessionStore, "where the sessions and the failed logins are kept, memory by default, lost on restart and not shared between replicas, or a store url like redis://:password@host:6379/0")
+	opts.Var(&s.Providers, optionProviders, fmt.Sprintf("comma separated providers to enable as <type> or <type>:<id>, types are %v", ProviderTypes()))
 	return s
 }
 
-// cliSettings returns settings acquired from CLI
-func cliSettings() Settings {
-	settings := Settings{}
-	flag.StringVar(&settings.HTTPAddress, optionHTTPAddress, defaultHTTPAddress, "<addr>:<port> to listen on for HTTP clients")
-	flag.StringVar(&settings.Upstream, optionUpstream, defaultUpstream, "the http url of the upstream endpoint")
-	flag.StringVar(&settings.CookieKey, optionCookieKey, defaultCookieKey, "the name of the secure cookies")
-	flag.StringVar(&settings.CookieSecret, optionCookieSecret, defaultCookieSecret, "the seed string for secure cookies")
-	flag.Int64Var(&settings.CookieExpiry, optionCookieExpiry, defaultCookieExpiryHour, "cookie lifespan in hour")
-	flag.Parse()
-	return settings
-}
-
-// envSettings returns settings acquired from environment variables
-func envSettings() Settings {
-	settings := Settings{}
-	EnvStringVar(&settings.HTTPAddress, optionHTTPAddress, defaultHTTPAddress)
-	EnvStringVar(&settings.Upstream, optionUpstream, defaultUpstream)
-	EnvStringVar(&settings.CookieKey, optionCookieKey, defaultCookieKey)
-	EnvStringVar(&settings.CookieSecret, optionCookieSecret, defaultCookieSecret)
-	EnvInt64Var(&settings.CookieExpiry, optionCookieExpiry, defaultCookieExpiryHour)
-	return settings
+// Parse resolves the settings from cli, env and the config file
+// Settings priority CLI > Options > ENV > config file
+// Every invalid option is reported at once as ValidationErrors
+func (s *Settings) Parse() error {
+	var errs ValidationErrors
+	errs.Add(s.options.Parse())
+	errs.Add(s.validateSettings())
+	return errs.Err()
 }
 
 // EnvStringVar is helper function for environment variables lookip
 func EnvStringVar(option *string, field, defaultval string) {
-	if value := os.Getenv(field); value != "" {
+	if value, _ := LookupEnv(field); value != "" {
 		*option = value
 	} else {
 		*option = defaultval
 	}
 }
 
+// EnvInt64Var is helper function for integer environment variables lookup,
+// an invalid value exits the process
+//
+// Deprecated: use ParseEnvInt64Var, it returns the invalid value error
 func EnvInt64Var(option *int64, field string, defaultval int64) {
-	stringval := os.Getenv(field)
+	if err := ParseEnvInt64Var(option, field, defaultval); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ParseEnvInt64Var sets option from the environment variable of field, the
+// unset and non positive values give defaultval and the invalid ones an
+// InvalidParam error
+func ParseEnvInt64Var(option *int64, field string, defaultval int64) error {
+	stringval, _ := LookupEnv(field)
 	if stringval != "" {
 		value, err := strconv.ParseInt(stringval, 10, 64)
 		if err != nil {
-			log.Fatalf("param %s requires integer character", field)
+			*option = defaultval
+			return InvalidParam(field, errRequiresInteger)
 		}
 		if value > 0 {
 			*option = value
@@ -126,61 +276,143 @@ func EnvInt64Var(option *int64, field string, defaultval int64) {
 	} else {
 		*option = defaultval
 	}
+	return nil
 }
 
-// merge filters settings values from CLI and ENV according to preset priority
-func merge(cli Settings, env Settings) Settings {
-	settings := Settings{}
-	settings.HTTPAddress = TopString(cli.HTTPAddress, env.HTTPAddress, defaultHTTPAddress)
-	settings.Upstream = TopString(cli.Upstream, env.Upstream, defaultUpstream)
-	settings.CookieKey = TopString(cli.CookieKey, env.CookieKey, defaultCookieKey)
-	settings.CookieSecret = TopString(cli.CookieSecret, env.CookieSecret, defaultCookieSecret)
-	settings.CookieExpiry = TopInt64(cli.CookieExpiry, env.CookieExpiry, defaultCookieExpiryHour)
-	return settings
-}
-
-// TopVar applies the settings priority rule and filter the one with highest priority
-// returns default value if available
-func TopString(cli, env, defaultval string) string {
-	if cli != "" && cli != defaultval {
-		return cli
+func (s *Settings) validateSettings() error {
+	var errs ValidationErrors
+	if s.HTTPAddress == "" {
+		errs.Add(MissingParam(optionHTTPAddress))
 	}
-	if env != "" {
-		return env
+	listeners := []struct {
+		option, addr string
+	}{
+		{optionHTTPAddress, s.HTTPAddress},
+		{optionAdminAddress, s.AdminAddress},
+		{optionMetricsAddress, s.MetricsAddress},
+		{optionHTTPRedirect, s.HTTPRedirectAddress},
 	}
-	return defaultval
-}
-
-func TopInt64(cli, env, defaultval int64) int64 {
-	if cli > 0 && cli != defaultval {
-		return cli
+	for _, l := range listeners {
+		if path, ok := socketPath(l.addr); ok && path == "" {
+			errs.Add(InvalidParam(l.option, errSocketPath))
+		}
 	}
-	if env > 0 {
-		return env
+	if s.MetricsAddress != "" && !metricsBuilt {
+		errs.Add(InvalidParam(optionMetricsAddress, errNoMetrics))
 	}
-	return defaultval
-}
-
-func (s *Settings) validateSettings() {
-	if s.HTTPAddress == "" {
-		log.Fatalf("missing param %s", optionHTTPAddress)
+	mode, err := parseSocketMode(s.SocketMode)
+	if err != nil {
+		errs.Add(InvalidParam(optionSocketMode, err))
 	}
+	s.socketMode = mode
 
-	if s.Upstream == "" {
-		log.Fatalf("missing param %s", optionUpstream)
+	// the entries are copied as a reload builds its own proxies
+	s.routes = nil
+	for _, u := range s.Upstreams {
+		route := *u
+		s.routes = append(s.routes, &route)
+	}
+	errs.Add(s.routes.init())
+	if s.Upstream != "" {
+		route := &Upstream{URL: s.Upstream}
+		if err := route.init(optionUpstream); err != nil {
+			errs.Add(err)
+		} else {
+			s.UpstreamURL = route.target
+			s.routes = append(s.routes, route)
+		}
+	}
+	if len(s.routes) == 0 && len(errs) == 0 {
+		errs.Add(MissingParam(optionUpstream))
 	}
 
 	if s.CookieKey == "" {
-		log.Fatalf("missing param %s", optionCookieKey)
+		errs.Add(MissingParam(optionCookieKey))
 	}
 
-	if s.CookieSecret == "" || s.CookieSecret == defaultCookieSecret {
-		h := sha1.New()
-		io.WriteString(h, fmt.Sprintf("%d", time.Now().Unix()))
-		s.CookieSecret = hex.EncodeToString(h.Sum(nil))
+	if s.CookieSecret == "" && s.SessionStore != "" && s.SessionStore != memoryStore {
+		// a secret per process breaks the cookies of the other replicas
+		errs.Add(MissingParam(optionCookieSecret))
+	} else if s.CookieSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			errs.Add(InvalidParam(optionCookieSecret, err))
+		}
+		s.CookieSecret = hex.EncodeToString(secret)
+		s.generatedSecret = true
 	}
 
 	if s.CookieExpiry < 1 {
-		log.Fatalf("missing param %s", optionCookieSecret)
+		errs.Add(MissingParam(optionCookieExpiry))
+	}
+	durations := []struct {
+		option string
+		value  int64
+	}{
+		{optionStreamCheck, s.StreamCheckInterval},
+		{optionReadTimeout, s.ReadTimeout},
+		{optionWriteTimeout, s.WriteTimeout},
+		{optionIdleTimeout, s.IdleTimeout},
+		{optionShutdownTimeout, s.ShutdownTimeout},
+		{optionShutdownDelay, s.ShutdownDelay},
+		{optionLoginMaxFailure, s.LoginMaxFailures},
+		{optionLoginMaxIP, s.LoginMaxFailuresIP},
+		{optionLoginBackoff, s.LoginBackoff},
+		{optionLoginLockout, s.LoginLockout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs.Add(InvalidParam(d.option, errNegative))
+		}
+	}
+
+	s.logger, err = newLogger(s.LogFormat, s.LogLevel)
+	errs.Add(err)
+	errs.Add(s.validateTracing())
+	if s.HealthPrefix != "" && (!strings.HasPrefix(s.HealthPrefix, "/") || strings.HasSuffix(s.HealthPrefix, "/")) {
+		errs.Add(InvalidParam(optionHealthPrefix, errHealthPrefix))
+	}
+	if s.SessionStore != "" && s.SessionStore != memoryStore {
+		if _, _, err := lookupSessionStore(s.SessionStore); err != nil {
+			errs.Add(InvalidParam(optionSessionStore, err))
+		}
+	}
+	if s.trustedProxies, err = parseTrustedProxies(s.TrustedProxies); err != nil {
+		errs.Add(InvalidParam(optionTrustedProxies, err))
+	}
+
+	tlsConfig, err := s.newTLSConfig()
+	errs.Add(err)
+	s.tlsConfig = tlsConfig
+	return errs.Err()
+}
+
+// listenerChanges lists the options changed since previous which are only
+// read on start, by the listeners and the session store
+func (s *Settings) listenerChanges(previous *Settings) []string {
+	var changes []string
+	values := []struct {
+		option          string
+		current, before string
+	}{
+		{optionHTTPAddress, s.HTTPAddress, previous.HTTPAddress},
+		{optionAdminAddress, s.AdminAddress, previous.AdminAddress},
+		{optionMetricsAddress, s.MetricsAddress, previous.MetricsAddress},
+		{optionTLSCert, s.TLSCert, previous.TLSCert},
+		{optionTLSKey, s.TLSKey, previous.TLSKey},
+		{optionTLSMinVersion, s.TLSMinVersion, previous.TLSMinVersion},
+		{optionTLSCipherSuites, s.TLSCipherSuites.String(), previous.TLSCipherSuites.String()},
+		{optionHTTPRedirect, s.HTTPRedirectAddress, previous.HTTPRedirectAddress},
+		{optionReadTimeout, strconv.FormatInt(s.ReadTimeout, 10), strconv.FormatInt(previous.ReadTimeout, 10)},
+		{optionWriteTimeout, strconv.FormatInt(s.WriteTimeout, 10), strconv.FormatInt(previous.WriteTimeout, 10)},
+		{optionIdleTimeout, strconv.FormatInt(s.IdleTimeout, 10), strconv.FormatInt(previous.IdleTimeout, 10)},
+		{optionSocketMode, s.SocketMode, previous.SocketMode},
+		{optionSessionStore, s.SessionStore, previous.SessionStore},
+	}
+	for _, v := range values {
+		if v.current != v.before {
+			changes = append(changes, v.option)
+		}
 	}
+	return changes
 }
@@ -0,0 +1,49 @@
+package pintu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSharedStoreRequiresCookieSecret(t *testing.T) {
+	tests := []struct {
+		store, secret string
+		missing       bool
+	}{
+		{"", "", false},
+		{"memory", "", false},
+		{"redis://127.0.0.1:6379/0", "", true},
+		{"redis://127.0.0.1:6379/0", "not so secret", false},
+	}
+	for _, test := range tests {
+		t.Setenv(EnvName(optionSessionStore), test.store)
+		p := NewPintu(Options{Upstream: "http://127.0.0.1:8080", CookieSecret: test.secret})
+		err := p.PrintConfig(&bytes.Buffer{})
+		if missing := err != nil && strings.Contains(err.Error(), "missing param "+optionCookieSecret); missing != test.missing {
+			t.Errorf("session_store %q, cookie_secret %q: %v", test.store, test.secret, err)
+		}
+	}
+}
+
+func TestParseEnvInt64Var(t *testing.T) {
+	tests := []struct {
+		env   string
+		value int64
+		valid bool
+	}{
+		{"", 168, true},
+		{"24", 24, true},
+		{"0", 168, true},
+		{"-1", 168, true},
+		{"a day", 168, false},
+	}
+	for _, test := range tests {
+		t.Setenv(EnvName(optionCookieExpiry), test.env)
+		var value int64
+		err := ParseEnvInt64Var(&value, optionCookieExpiry, 168)
+		if (err == nil) != test.valid || value != test.value {
+			t.Errorf("ParseEnvInt64Var() of %q = %d, %v", test.env, value, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package pintu
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// newServer builds a listener with the configured timeouts, it is shut down
+// along with pintu
+func (p *Pintu) newServer(addr string, handler http.Handler) *http.Server {
+	settings := p.runtime().settings
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  time.Duration(settings.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(settings.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(settings.IdleTimeout) * time.Second,
+		ErrorLog:     slog.NewLogLogger(Logger().Handler(), slog.LevelWarn),
+	}
+	p.serversMu.Lock()
+	p.servers = append(p.servers, server)
+	p.serversMu.Unlock()
+	return server
+}
+
+// wait serves until a listener fails or SIGTERM or SIGINT is received, the
+// shutdown is then graceful
+func (p *Pintu) wait(errc <-chan error) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-stop:
+		Logger().Info("shutting down", "signal", sig.String())
+	}
+	settings := p.runtime().settings
+	timeout := time.Duration(settings.ShutdownDelay+settings.ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return p.Shutdown(ctx)
+}
+
+// Shutdown fails the readiness, waits for the shutdown delay so the load
+// balancers stop sending requests, stops accepting connections and drains
+// the requests in flight until ctx is done, then closes the session store
+// and the audit sinks and flushes the traces
+func (p *Pintu) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&p.draining, 1)
+	rt, _ := p.current.Load().(*runtime)
+	if rt != nil && rt.settings.ShutdownDelay > 0 {
+		delay := time.Duration(rt.settings.ShutdownDelay) * time.Second
+		Logger().Info("not ready, draining", "delay", delay.String())
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	p.serversMu.Lock()
+	servers := p.servers
+	p.serversMu.Unlock()
+	var err error
+	for _, server := range servers {
+		if e := server.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	if rt != nil {
+		rt.settings.routes.Close()
+	}
+	if p.sessions != nil {
+		if e := p.sessions.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if rt != nil {
+		if e := rt.audit.Close(ctx); e != nil && err == nil {
+			err = e
+		}
+		shutdownTracing(ctx, rt.tracing)
+	}
+	if err != nil {
+		Logger().Error("shutdown incomplete", "err", err.Error())
+		return err
+	}
+	Logger().Info("shutdown complete")
+	return nil
+}
+
+// Ready tells whether pintu accepts traffic, it turns false on shutdown
+func (p *Pintu) Ready() bool {
+	return atomic.LoadInt32(&p.draining) == 0
+}
+
+// logServe reports a listener failure, the shutdown is not one
+func logServe(err error) {
+	if err != http.ErrServerClosed {
+		Logger().Error("listener failed", "err", err.Error())
+	}
+}
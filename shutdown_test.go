@@ -0,0 +1,112 @@
+package pintu
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closedStore records its Close
+type closedStore struct {
+	*MemoryStore
+	closed atomic.Bool
+}
+
+func (s *closedStore) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+// startTestServer serves handler on a pintu listener of a local port
+func startTestServer(t *testing.T, p *Pintu, handler http.Handler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := p.newServer(l.Addr().String(), handler)
+	go server.Serve(l)
+	return "http://" + l.Addr().String()
+}
+
+func TestShutdownDrains(t *testing.T) {
+	store := &closedStore{MemoryStore: NewMemoryStore()}
+	p := NewPintu(Options{SessionStore: store})
+	p.current.Store(&runtime{settings: &Settings{ShutdownDelay: 1}})
+	started, release := make(chan struct{}), make(chan struct{})
+	url := startTestServer(t, p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		io.WriteString(w, "done")
+	}))
+
+	inflight := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "done" {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		inflight <- err
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- p.Shutdown(ctx)
+	}()
+	eventually(t, "the draining", func() bool { return !p.Ready() })
+	if status := p.cachedReadiness(context.Background()).Status; status != "draining" {
+		t.Fatalf("readiness %s while draining", status)
+	}
+	// the load balancers may still send requests during the delay
+	resp, err := http.Get(url + "/")
+	if err != nil {
+		t.Fatalf("request during the shutdown delay: %v", err)
+	}
+	resp.Body.Close()
+
+	close(release)
+	if err := <-inflight; err != nil {
+		t.Fatalf("the request in flight was cut: %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if !store.closed.Load() {
+		t.Fatal("Shutdown() left the session store open")
+	}
+	if _, err := http.Get(url + "/"); err == nil {
+		t.Fatal("the listener still accepts connections")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	p := NewPintu(Options{})
+	p.current.Store(&runtime{settings: &Settings{}})
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	url := startTestServer(t, p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go http.Get(url)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() past its deadline = %v", err)
+	}
+}
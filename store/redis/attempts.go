@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/Tuxuri/pintu"
+)
+
+// the scripts run atomically so the replicas counting the same key in
+// parallel see each other's attempts
+var (
+	addAttempt = goredis.NewScript(`
+local previous = redis.call('HMGET', KEYS[1], 'failures', 'last')
+redis.call('HINCRBY', KEYS[1], 'failures', 1)
+redis.call('HSET', KEYS[1], 'last', ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return previous`)
+
+	removeAttempt = goredis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 and redis.call('HINCRBY', KEYS[1], 'failures', -1) <= 0 then
+	redis.call('DEL', KEYS[1])
+end
+return 0`)
+)
+
+func (s *Store) attemptKey(key string) string {
+	return s.prefix + "attempts:" + key
+}
+
+// AddAttempt counts an attempt of key and returns the attempts before it,
+// the key expires ttl after its last attempt
+func (s *Store) AddAttempt(ctx context.Context, key string, ttl time.Duration) (pintu.Attempts, error) {
+	now := time.Now()
+	result, err := addAttempt.Run(ctx, s.client, []string{s.attemptKey(key)},
+		now.UnixMilli(), ttl.Milliseconds()).Slice()
+	if err != nil {
+		return pintu.Attempts{}, err
+	}
+	var previous pintu.Attempts
+	if len(result) == 2 {
+		if failures, ok := result[0].(string); ok {
+			previous.Failures, _ = strconv.Atoi(failures)
+		}
+		if last, ok := result[1].(string); ok {
+			ms, _ := strconv.ParseInt(last, 10, 64)
+			previous.Last = time.UnixMilli(ms)
+		}
+	}
+	return previous, nil
+}
+
+func (s *Store) RemoveAttempt(ctx context.Context, key string) error {
+	return removeAttempt.Run(ctx, s.client, []string{s.attemptKey(key)}).Err()
+}
+
+func (s *Store) ResetAttempts(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.attemptKey(key)).Err()
+}
@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tuxuri/pintu"
+)
+
+var _ pintu.AttemptStore = (*Store)(nil)
+
+func TestAttempts(t *testing.T) {
+	store, server := newTestStore(t)
+	ctx := context.Background()
+
+	previous, err := store.AddAttempt(ctx, "user:alice", time.Minute)
+	if err != nil || previous.Failures != 0 {
+		t.Fatalf("first AddAttempt() = %v, %v", previous, err)
+	}
+	before := time.Now()
+	store.AddAttempt(ctx, "user:alice", time.Minute)
+	previous, _ = store.AddAttempt(ctx, "user:alice", time.Minute)
+	if previous.Failures != 2 || previous.Last.Before(before.Add(-time.Second)) {
+		t.Fatalf("AddAttempt() = %v, want 2 failures", previous)
+	}
+
+	store.RemoveAttempt(ctx, "user:alice")
+	if previous, _ = store.AddAttempt(ctx, "user:alice", time.Minute); previous.Failures != 2 {
+		t.Fatalf("AddAttempt() after RemoveAttempt() = %d failures, want 2", previous.Failures)
+	}
+	store.ResetAttempts(ctx, "user:alice")
+	if previous, _ = store.AddAttempt(ctx, "user:alice", time.Minute); previous.Failures != 0 {
+		t.Fatalf("AddAttempt() after ResetAttempts() = %d failures", previous.Failures)
+	}
+	store.RemoveAttempt(ctx, "user:alice")
+	if server.Exists("test:attempts:user:alice") {
+		t.Fatal("RemoveAttempt() of the last attempt kept the key")
+	}
+
+	store.AddAttempt(ctx, "ip:192.0.2.1", time.Minute)
+	server.FastForward(time.Minute)
+	if previous, _ = store.AddAttempt(ctx, "ip:192.0.2.1", time.Minute); previous.Failures != 0 {
+		t.Fatal("the attempts did not expire")
+	}
+}
+
+func TestParallelAttempts(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	const n = 20
+	seen := make([]bool, n)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			previous, err := store.AddAttempt(ctx, "user:bob", time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			seen[previous.Failures] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	for failures, ok := range seen {
+		if !ok {
+			t.Fatalf("no attempt saw %d previous failures", failures)
+		}
+	}
+}
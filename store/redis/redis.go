@@ -0,0 +1,165 @@
+// Package redis keeps the pintu sessions and login attempts in redis so
+// they survive the restarts and are shared between the replicas, enabled by
+// a redis:// or rediss:// session_store url
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/Tuxuri/pintu"
+)
+
+type (
+	// Store is a pintu.SessionStore, every session is a key expiring with
+	// it and a sorted set by expiry lists them
+	Store struct {
+		client goredis.UniversalClient
+		prefix string
+	}
+)
+
+const defaultPrefix = "pintu:"
+
+func init() {
+	for _, scheme := range []string{"redis", "rediss"} {
+		pintu.RegisterSessionStore(scheme, func(u *url.URL) (pintu.SessionStore, error) {
+			return Open(u)
+		})
+	}
+}
+
+// Open connects to the redis of u, the prefix query parameter namespaces
+// the keys, pintu: by default
+func Open(u *url.URL) (*Store, error) {
+	prefix := defaultPrefix
+	query := u.Query()
+	if query.Has("prefix") {
+		prefix = query.Get("prefix")
+		query.Del("prefix")
+	}
+	clean := *u
+	clean.RawQuery = query.Encode()
+	options, err := goredis.ParseURL(clean.String())
+	if err != nil {
+		return nil, errors.New("invalid redis url")
+	}
+	return New(goredis.NewClient(options), prefix), nil
+}
+
+// New keeps the sessions with client under the prefix keys
+func New(client goredis.UniversalClient, prefix string) *Store {
+	return &Store{client: client, prefix: prefix}
+}
+
+func (s *Store) sessionKey(id string) string {
+	return s.prefix + "session:" + id
+}
+
+func (s *Store) indexKey() string {
+	return s.prefix + "sessions"
+}
+
+// Save stores the session until its expiry and drops the expired ones from
+// the index
+func (s *Store) Save(ctx context.Context, session *pintu.Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	_, err = s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Set(ctx, s.sessionKey(session.ID), data, ttl)
+		pipe.ZAdd(ctx, s.indexKey(), goredis.Z{Score: float64(session.ExpiresAt.Unix()), Member: session.ID})
+		pipe.ZRemRangeByScore(ctx, s.indexKey(), "-inf", "("+now)
+		return nil
+	})
+	return err
+}
+
+func (s *Store) Load(ctx context.Context, id string) (*pintu.Session, error) {
+	data, err := s.client.Get(ctx, s.sessionKey(id)).Bytes()
+	if err == goredis.Nil {
+		return nil, pintu.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := &pintu.Session{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	if session.Expired(time.Now()) {
+		return nil, pintu.ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *Store) Delete(ctx context.Context, id string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Del(ctx, s.sessionKey(id))
+		pipe.ZRem(ctx, s.indexKey(), id)
+		return nil
+	})
+	return err
+}
+
+// List returns the live sessions, oldest first
+func (s *Store) List(ctx context.Context) ([]*pintu.Session, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	ids, err := s.client.ZRangeByScore(ctx, s.indexKey(), &goredis.ZRangeBy{Min: now, Max: "+inf"}).Result()
+	if err != nil || len(ids) == 0 {
+		return []*pintu.Session{}, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.sessionKey(id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*pintu.Session, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		session := &pintu.Session{}
+		if err := json.Unmarshal([]byte(data), session); err != nil || session.Expired(time.Now()) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// CountSessions counts the index entries which are not expired
+func (s *Store) CountSessions(ctx context.Context) (int, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	count, err := s.client.ZCount(ctx, s.indexKey(), now, "+inf").Result()
+	return int(count), err
+}
+
+func (s *Store) Close() error {
+	return s.client.Close()
+}
+
+// CheckHealth fails /ready while redis does not answer
+func (s *Store) CheckHealth(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
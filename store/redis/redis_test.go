@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/Tuxuri/pintu"
+)
+
+func newTestStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	u, _ := url.Parse("redis://" + server.Addr() + "/0?prefix=test:")
+	store, err := Open(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, server
+}
+
+func TestSessions(t *testing.T) {
+	store, server := newTestStore(t)
+	ctx := context.Background()
+
+	first, _ := pintu.NewSession("alice@example.com", "htpasswd", time.Hour)
+	second, _ := pintu.NewSession("bob@example.com", "htpasswd", 2*time.Hour)
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+	for _, session := range []*pintu.Session{second, first} {
+		if err := store.Save(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !server.Exists("test:session:" + first.ID) {
+		t.Fatal("session key is not prefixed")
+	}
+
+	loaded, err := store.Load(ctx, first.ID)
+	if err != nil || loaded.Email != first.Email {
+		t.Fatalf("Load() = %v, %v", loaded, err)
+	}
+	list, err := store.List(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != first.ID {
+		t.Fatalf("List() = %v, %v, want oldest first", list, err)
+	}
+	if count, err := store.CountSessions(ctx); err != nil || count != 2 {
+		t.Fatalf("CountSessions() = %d, %v, want 2", count, err)
+	}
+
+	if err := store.Delete(ctx, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(ctx, first.ID); err != pintu.ErrSessionNotFound {
+		t.Fatalf("Load() of a deleted session = %v", err)
+	}
+
+	server.FastForward(2 * time.Hour)
+	if _, err := store.Load(ctx, second.ID); err != pintu.ErrSessionNotFound {
+		t.Fatalf("Load() of an expired session = %v", err)
+	}
+	if err := store.CheckHealth(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenSessionStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	store, err := pintu.OpenSessionStore("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, ok := store.(*Store); !ok {
+		t.Fatalf("OpenSessionStore() = %T", store)
+	}
+	if _, err := pintu.OpenSessionStore("memcache://" + server.Addr()); err == nil {
+		t.Fatal("OpenSessionStore() accepted an unknown scheme")
+	}
+}
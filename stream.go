@@ -0,0 +1,52 @@
+package pintu
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// isStream tells the long lived requests, websocket upgrades and server
+// sent events
+func isStream(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" {
+		for _, value := range strings.Split(r.Header.Get("Connection"), ",") {
+			if strings.EqualFold(strings.TrimSpace(value), "upgrade") {
+				return true
+			}
+		}
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// watchSession cancels the request of a stream once its session expires or
+// is revoked, the reverse proxy then closes both ends of the connection
+func (g *Guard) watchSession(r *http.Request, session *Session) *http.Request {
+	if g.streamCheck <= 0 || session == nil {
+		return r
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(g.streamCheck)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if session.Expired(now) {
+					RequestLogger(r).Info("closing stream, session expired", "path", r.URL.Path, "user", session.Email)
+					return
+				}
+				// an unreachable store leaves the stream open
+				if _, err := g.sessions.Load(ctx, session.ID); err == ErrSessionNotFound {
+					RequestLogger(r).Info("closing stream, session revoked", "path", r.URL.Path, "user", session.Email)
+					return
+				}
+			}
+		}
+	}()
+	return r.WithContext(ctx)
+}
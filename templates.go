@@ -12,6 +12,8 @@ type LoginPartial struct {
 	Name     string
 	Type     string
 	Btn      string
+	// CSRF is the token of the pre-auth cookie, see CSRFToken
+	CSRF string
 }
 
 func (p *LoginPartial) GetForm(r *http.Request) string {
@@ -25,6 +27,9 @@ func (p *LoginPartial) GetLink(r *http.Request) string {
 }
 
 func (p *LoginPartial) RenderPartial(r *http.Request, t *template.Template) string {
+	if p.CSRF == "" {
+		p.CSRF = CSRFToken(r)
+	}
 	buffer := new(bytes.Buffer)
 	t.ExecuteTemplate(buffer, "partial.html", p)
 	return buffer.String()
@@ -101,6 +106,57 @@ func GetTemplates() *template.Template {
     <script>$(function () { $.material.init(); });</script>
   </body>
 </html>
+{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "mfa.html"}}
+<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Second factor</title>
+    <link rel="stylesheet" href="//cdnjs.cloudflare.com/ajax/libs/twitter-bootstrap/3.3.1/css/bootstrap.min.css">
+    <link rel="stylesheet" href="//cdnjs.cloudflare.com/ajax/libs/bootstrap-material-design/0.1.6/css/material.min.css">
+    <link rel="stylesheet" href="//cdnjs.cloudflare.com/ajax/libs/bootstrap-material-design/0.1.6/css/material-wfont.min.css">
+    <link rel="stylesheet" href="//cdnjs.cloudflare.com/ajax/libs/font-awesome/4.2.0/css/font-awesome.min.css">
+  </head>
+  <body>
+    <div class="container-fluid">
+      {{if .QRCode}}
+      <h2 class="text-center">Enroll your authenticator app</h2>
+      {{else}}
+      <h2 class="text-center">Enter your authentication code</h2>
+      {{end}}
+      <div class="row-fluid">
+        <div class="col-md-offset-4 col-md-4">
+          {{if .QRCode}}
+          <p class="text-center"><img src="{{.QRCode}}" alt="TOTP QR code" width="256" height="256"></p>
+          <p class="text-center">or enter the key <code>{{.Secret}}</code></p>
+          {{end}}
+          {{if .Error}}<div class="alert alert-danger">{{.Error}}</div>{{end}}
+          <form method="POST" action="{{.Action}}" role="form">
+            <fieldset>
+              <input type="hidden" name="rd" value="{{.Redirect}}">
+              <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+              {{if .Enrollment}}<input type="hidden" name="enrollment" value="{{.Enrollment}}">{{end}}
+              <div class="form-group">
+                <input type="text" name="code" class="form-control" placeholder="123456" inputmode="numeric" autocomplete="one-time-code" pattern="[0-9]{6}" required autofocus>
+              </div>
+              <div class="form-group">
+                <button class="btn btn-lg btn-primary btn-block" type="submit">
+                  <i class="fa fa-lg fa-lock"></i>
+                  Verify
+                </button>
+              </div>
+            </fieldset>
+          </form>
+          <p class="text-center"><a href="/auth/logout">Sign out</a></p>
+        </div>
+      </div>
+    </div>
+  </body>
+</html>
 {{end}}`))
 
 	return t
@@ -131,6 +187,7 @@ func LoginFormTemplate() *template.Template {
       <form method="POST" action="{{.Action}}" role="form">
         <fieldset>
           <input type="hidden" name="rd" value="{{.Redirect}}">
+          <input type="hidden" name="csrf_token" value="{{.CSRF}}">
           <div class="form-group">
             <input type="login" name="username" class="form-control" placeholder="Username" required autofocus>
             <input type="password" name="password" class="form-control" placeholder="Password" required>
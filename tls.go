@@ -0,0 +1,195 @@
+package pintu
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certificate serves the tls key pair and loads it again once the files
+// change, ie renewed by certbot, a broken renewal keeps the previous pair
+type certificate struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// certificateCheckInterval throttles the files stat on handshakes
+const certificateCheckInterval = 10 * time.Second
+
+var (
+	errTLSPair       = errors.New("tls_cert and tls_key go together")
+	errTLSMinVersion = errors.New("supported versions are 1.0, 1.1, 1.2 and 1.3")
+	errNoTLS         = errors.New("requires pintu to terminate tls, set tls_cert and tls_key")
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func newCertificate(certFile, keyFile string) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certificate) load() error {
+	modTime, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	c.checked = time.Now()
+	return nil
+}
+
+func (c *certificate) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return last, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) < certificateCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	if modTime, err := c.lastModified(); err == nil && !modTime.Equal(c.modTime) {
+		if err := c.load(); err != nil {
+			Logger().Error("tls certificate reload failed, keeping the previous one", "err", err.Error())
+		} else {
+			Logger().Info("tls certificate reloaded", "file", c.certFile)
+		}
+	}
+	return c.cert, nil
+}
+
+// newTLSConfig validates the tls settings, it returns nil when tls is off
+func (s *Settings) newTLSConfig() (*tls.Config, error) {
+	var errs ValidationErrors
+	if s.TLSCert == "" && s.TLSKey == "" {
+		if s.HTTPRedirectAddress != "" {
+			errs.Add(InvalidParam(optionHTTPRedirect, errors.New("requires tls")))
+		}
+		return nil, errs.Err()
+	}
+	if s.TLSCert == "" || s.TLSKey == "" {
+		return nil, InvalidParam(optionTLSCert, errTLSPair)
+	}
+
+	config := &tls.Config{}
+	cert, err := newCertificate(s.TLSCert, s.TLSKey)
+	if err != nil {
+		errs.Add(InvalidParam(optionTLSCert, err))
+	} else {
+		config.GetCertificate = cert.GetCertificate
+	}
+
+	version, ok := tlsVersions[s.TLSMinVersion]
+	if !ok {
+		errs.Add(InvalidParam(optionTLSMinVersion, errTLSMinVersion))
+	}
+	config.MinVersion = version
+
+	if len(s.TLSCipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range s.TLSCipherSuites {
+			id, ok := suites[strings.ToUpper(name)]
+			if !ok {
+				errs.Add(InvalidParam(optionTLSCipherSuites, fmt.Errorf("unknown or insecure cipher suite %s", name)))
+				continue
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// checkClientCertificates rejects the providers reading the tls client
+// certificate when pintu does not terminate tls, they would never see one
+func checkClientCertificates(providers []Provider, s *Settings) error {
+	if s.tlsConfig != nil {
+		return nil
+	}
+	var errs ValidationErrors
+	for _, p := range providers {
+		a, ok := p.(*authProvider)
+		if !ok {
+			continue
+		}
+		if c, ok := a.Authenticator.(ClientCertificates); ok && c.ClientCertificates() {
+			errs.Add(InvalidParam(optionProviders, fmt.Errorf("%s %w", p.Name(), errNoTLS)))
+		}
+	}
+	return errs.Err()
+}
+
+// serverTLSConfig asks the clients for their certificate while a provider of
+// the running configuration uses them, the browsers are left alone otherwise
+func (p *Pintu) serverTLSConfig(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	if len(config.NextProtos) == 0 {
+		// http.Server only offers h2 on its own copy, not on the config
+		// returned per client
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	requestCert := config.Clone()
+	requestCert.ClientAuth = tls.RequestClientCert
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		if p.runtime().clientCertificates {
+			return requestCert, nil
+		}
+		return nil, nil
+	}
+	return config
+}
+
+// redirectHTTPS sends the plain http clients to the https listener
+func redirectHTTPS(httpsAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddress)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := GetDomain(r)
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
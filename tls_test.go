@@ -0,0 +1,186 @@
+package pintu
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// certificateStub is a RequestProvider reading the client certificates
+type certificateStub struct{}
+
+func (certificateStub) ID() string               { return "" }
+func (certificateStub) Name() string             { return "Client certificate" }
+func (certificateStub) Path() string             { return "/auth/stub" }
+func (certificateStub) ParseSettings() error     { return nil }
+func (certificateStub) ClientCertificates() bool { return true }
+func (certificateStub) AuthenticateRequest(r *http.Request) (*Identity, error) {
+	return nil, nil
+}
+
+func TestClientCertificatesRequireTLS(t *testing.T) {
+	providers := []Provider{AdaptAuthenticator(certificateStub{}), AdaptAuthenticator(redirectStub{})}
+	err := checkClientCertificates(providers, &Settings{})
+	if err == nil || !strings.Contains(err.Error(), errNoTLS.Error()) {
+		t.Fatalf("checkClientCertificates() without tls = %v", err)
+	}
+	if err := checkClientCertificates(providers, &Settings{tlsConfig: &tls.Config{}}); err != nil {
+		t.Fatalf("checkClientCertificates() with tls = %v", err)
+	}
+	if err := checkClientCertificates(providers[1:], &Settings{}); err != nil {
+		t.Fatalf("checkClientCertificates() without certificate provider = %v", err)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key to the cert.pem and key.pem files of dir
+func writeTestCertificate(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestServerTLSConfigKeepsHTTP2(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir(), "pintu")
+	settings := &Settings{TLSCert: certFile, TLSKey: keyFile, TLSMinVersion: "1.2"}
+	base, err := settings.newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, clientCertificates := range []bool{false, true} {
+		p := &Pintu{}
+		p.current.Store(&runtime{clientCertificates: clientCertificates})
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto)
+		}))
+		server.EnableHTTP2 = true
+		server.TLS = p.serverTLSConfig(base)
+		server.StartTLS()
+		client := server.Client()
+		client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		server.Close()
+		if string(body) != "HTTP/2.0" {
+			t.Errorf("client certificates %v: served over %s, want HTTP/2.0", clientCertificates, body)
+		}
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, "first")
+	c, err := newCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// renew moves the mtime of the files on and lifts the stat throttle
+	renew := func(write func()) string {
+		write()
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(certFile, later, later)
+		c.mu.Lock()
+		c.checked = time.Time{}
+		c.mu.Unlock()
+		cert, err := c.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	tests := []struct {
+		name  string
+		write func()
+		want  string
+	}{
+		{"unchanged", func() {}, "first"},
+		{"renewed", func() { writeTestCertificate(t, dir, "second") }, "second"},
+		{"broken key", func() { os.WriteFile(keyFile, []byte("broken"), 0600) }, "second"},
+		{"removed", func() { os.Remove(certFile) }, "second"},
+		{"renewed again", func() { writeTestCertificate(t, dir, "third") }, "third"},
+	}
+	for _, test := range tests {
+		if got := renew(test.write); got != test.want {
+			t.Errorf("%s: serving %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	cert, _ := c.GetCertificate(nil)
+	writeTestCertificate(t, dir, "throttled")
+	if again, _ := c.GetCertificate(nil); again != cert {
+		t.Fatal("the files were read again within certificateCheckInterval")
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir(), "pintu")
+	tests := []struct {
+		settings Settings
+		err      string
+	}{
+		{Settings{TLSMinVersion: "1.2"}, ""},
+		{Settings{TLSMinVersion: "1.2", HTTPRedirectAddress: ":80"}, "requires tls"},
+		{Settings{TLSCert: certFile, TLSMinVersion: "1.2"}, errTLSPair.Error()},
+		{Settings{TLSCert: certFile, TLSKey: keyFile, TLSMinVersion: "1.2"}, ""},
+		{Settings{TLSCert: certFile, TLSKey: certFile, TLSMinVersion: "1.2"}, optionTLSCert},
+		{Settings{TLSCert: certFile, TLSKey: keyFile, TLSMinVersion: "1.4"}, errTLSMinVersion.Error()},
+		{Settings{TLSCert: certFile, TLSKey: keyFile, TLSMinVersion: "1.2", TLSCipherSuites: []string{"tls_ecdhe_ecdsa_with_aes_128_gcm_sha256"}}, ""},
+		{Settings{TLSCert: certFile, TLSKey: keyFile, TLSMinVersion: "1.2", TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, "insecure cipher suite"},
+	}
+	for i, test := range tests {
+		_, err := test.settings.newTLSConfig()
+		if (test.err == "") != (err == nil) || err != nil && !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%d: newTLSConfig() = %v, want %q", i, err, test.err)
+		}
+	}
+}
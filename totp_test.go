@@ -0,0 +1,64 @@
+package pintu
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the sha1 seed of the RFC 6238 test vectors
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, the last 6 of the 8 digits
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		if code := totpCode(rfc6238Secret, test.time/totpPeriod); code != test.code {
+			t.Errorf("totpCode(%d) = %s, want %s", test.time, code, test.code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name  string
+		step  int64
+		after int64
+		ok    bool
+	}{
+		{"current step", current, 0, true},
+		{"previous step", current - 1, 0, true},
+		{"next step", current + 1, 0, true},
+		{"two steps back", current - 2, 0, false},
+		{"two steps ahead", current + 2, 0, false},
+		{"replayed", current, current, false},
+		{"older than the last used", current - 1, current, false},
+		{"newer than the last used", current + 1, current, true},
+	}
+	for _, test := range tests {
+		step, ok := verifyTOTP(secret, totpCode(rfc6238Secret, test.step), test.after, now)
+		if ok != test.ok || (ok && step != test.step) {
+			t.Errorf("%s: verifyTOTP() = %d, %t, want %d, %t", test.name, step, ok, test.step, test.ok)
+		}
+	}
+
+	if _, ok := verifyTOTP(secret, "000000", 0, now); ok {
+		t.Error("verifyTOTP() accepted a wrong code")
+	}
+	if _, ok := verifyTOTP("not base32!", "287082", 0, now); ok {
+		t.Error("verifyTOTP() accepted an invalid secret")
+	}
+}
@@ -0,0 +1,149 @@
+package pintu
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName      = "github.com/Tuxuri/pintu"
+	tracingShutdown = 5 * time.Second
+)
+
+type (
+	// tracerBox keeps a single concrete type in the atomic value
+	tracerBox struct {
+		trace.Tracer
+	}
+
+	// tracerProvider is the provider exporting to otlp_endpoint, the sdk
+	// and exporter are left out by the notracing tag
+	tracerProvider interface {
+		trace.TracerProvider
+		Shutdown(ctx context.Context) error
+	}
+)
+
+var (
+	tracer atomic.Value
+	// propagator reads and writes the w3c traceparent and tracestate headers
+	propagator = propagation.TraceContext{}
+
+	errOTLPEndpoint = errors.New("requires an http(s) url like http://localhost:4318")
+	errSamplePct    = errors.New("must be between 0 and 100")
+)
+
+func init() {
+	// the global provider, a no-op unless the embedder sets one
+	tracer.Store(tracerBox{otel.GetTracerProvider().Tracer(tracerName)})
+}
+
+// Tracer returns the tracer of pintu and its providers
+func Tracer() trace.Tracer {
+	return tracer.Load().(tracerBox).Tracer
+}
+
+// StartSpan starts a span of the pintu tracer, child of the span in ctx
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan records err and ends span, rejected credentials are not errors
+// of the system and leave the status unset
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		if err != ErrInvalidCredentials && err != ErrAccessDenied {
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+// validateTracing checks the otlp_endpoint and trace_sample_percent options
+func (s *Settings) validateTracing() error {
+	var errs ValidationErrors
+	if s.OTLPEndpoint != "" {
+		u, err := url.Parse(s.OTLPEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.Add(InvalidParam(optionOTLPEndpoint, errOTLPEndpoint))
+		}
+	}
+	if s.TraceSamplePercent < 0 || s.TraceSamplePercent > 100 {
+		errs.Add(InvalidParam(optionTraceSample, errSamplePct))
+	}
+	return errs.Err()
+}
+
+// shutdownTracing flushes the spans of a replaced or stopped provider
+func shutdownTracing(ctx context.Context, provider tracerProvider) {
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, tracingShutdown)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		Logger().Warn("tracing shutdown incomplete", "err", err.Error())
+	}
+}
+
+// traceRequest starts the server span of r from its traceparent, the
+// returned func ends it with the response status
+func traceRequest(w http.ResponseWriter, r *http.Request, name string) (*http.Request, func()) {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", r.Method),
+		attribute.String("url.path", r.URL.Path),
+		attribute.String("server.address", r.Host),
+		attribute.String("client.address", GetRemoteIP(r)),
+	}
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		attrs = append(attrs, attribute.String("pintu.request_id", info.id))
+	}
+	ctx, span := Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+	return r.WithContext(ctx), func() {
+		if res, ok := w.(negroni.ResponseWriter); ok && res.Status() != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", res.Status()))
+			if res.Status() >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(res.Status()))
+			}
+		}
+		span.End()
+	}
+}
+
+// traceUpstream starts the client span of an upstream request and passes
+// its traceparent on to the backend
+func traceUpstream(r *http.Request, upstream string, b *backend) (*http.Request, trace.Span) {
+	ctx, span := Tracer().Start(r.Context(), "upstream "+upstream,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("pintu.upstream", upstream),
+			attribute.String("pintu.backend", b.url.String()),
+			attribute.String("http.request.method", r.Method),
+		),
+	)
+	propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+	return r.WithContext(ctx), span
+}
+
+// traceResponse sets the backend status on the upstream span
+func traceResponse(resp *http.Response) error {
+	span := trace.SpanFromContext(resp.Request.Context())
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build notracing
+// +build notracing
+
+package pintu
+
+import "errors"
+
+var errNoTracing = errors.New("pintu was built with the notracing tag")
+
+// newTracerProvider rejects otlp_endpoint, the notracing tag leaves the
+// otel sdk and exporter out, the spans still reach an embedder provider
+func (s *Settings) newTracerProvider() (tracerProvider, error) {
+	if s.OTLPEndpoint == "" {
+		return nil, nil
+	}
+	return nil, InvalidParam(optionOTLPEndpoint, errNoTracing)
+}
@@ -0,0 +1,31 @@
+//go:build !notracing
+// +build !notracing
+
+package pintu
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newTracerProvider exports the spans to the otlp_endpoint collector, nil
+// when the endpoint is empty, the sampling follows the incoming traceparent
+func (s *Settings) newTracerProvider() (tracerProvider, error) {
+	if s.OTLPEndpoint == "" {
+		return nil, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(s.OTLPEndpoint))
+	if err != nil {
+		return nil, InvalidParam(optionOTLPEndpoint, err)
+	}
+	ratio := float64(s.TraceSamplePercent) / 100
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "pintu"))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	), nil
+}
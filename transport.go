@@ -0,0 +1,103 @@
+package pintu
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Transport tunes the connections of an upstream, the zero values keep the
+// go defaults
+type Transport struct {
+	// CA is a pem bundle trusted in place of the system roots
+	CA string `json:"ca"`
+	// Cert and Key authenticate pintu to mutual tls backends
+	Cert       string `json:"cert"`
+	Key        string `json:"key"`
+	ServerName string `json:"server_name"`
+	// InsecureSkipVerify disables the backend certificate verification, for
+	// tests only
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+
+	MaxIdleConns          int      `json:"max_idle_conns"`
+	MaxIdleConnsPerHost   int      `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int      `json:"max_conns_per_host"`
+	IdleConnTimeout       Duration `json:"idle_conn_timeout"`
+	DialTimeout           Duration `json:"dial_timeout"`
+	TLSHandshakeTimeout   Duration `json:"tls_handshake_timeout"`
+	ResponseHeaderTimeout Duration `json:"response_header_timeout"`
+}
+
+var (
+	errTransportPair = errors.New("cert and key go together")
+	errTransportCA   = errors.New("no certificate found in the ca bundle")
+)
+
+// newTransport builds the round tripper of an upstream, nil leaves the
+// default transport
+func (t *Transport) newTransport(option string) (*http.Transport, error) {
+	if t == nil {
+		return nil, nil
+	}
+	var errs ValidationErrors
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CA != "" {
+		data, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			errs.Add(InvalidParam(option+".ca", err))
+		} else {
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(data) {
+				errs.Add(InvalidParam(option+".ca", errTransportCA))
+			}
+		}
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		errs.Add(InvalidParam(option+".cert", errTransportPair))
+	} else if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			errs.Add(InvalidParam(option+".cert", err))
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if len(errs) > 0 {
+		return nil, errs.Err()
+	}
+	if t.InsecureSkipVerify {
+		Logger().Warn("upstream certificate verification skipped", "upstream", option)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	if t.DialTimeout > 0 {
+		dialer := &net.Dialer{Timeout: time.Duration(t.DialTimeout), KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+	}
+	if t.MaxIdleConns > 0 {
+		transport.MaxIdleConns = t.MaxIdleConns
+	}
+	if t.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+	}
+	if t.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = t.MaxConnsPerHost
+	}
+	if t.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(t.IdleConnTimeout)
+	}
+	if t.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = time.Duration(t.TLSHandshakeTimeout)
+	}
+	if t.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(t.ResponseHeaderTimeout)
+	}
+	return transport, nil
+}
@@ -0,0 +1,81 @@
+package pintu
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const unixScheme = "unix"
+
+var (
+	errSocketPath = errors.New("unix socket path is empty")
+	errSocketMode = errors.New("requires an octal file mode, ie 0660")
+)
+
+// socketPath returns the path of a unix:///path/to/pintu.sock address
+func socketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, unixScheme+"://") {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, unixScheme+"://"), true
+}
+
+// listen opens a tcp address or a unix socket with mode, a socket file left
+// by a previous run is replaced
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	path, ok := socketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen(unixScheme, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// listenAndServe serves server on its tcp address or unix socket
+func (p *Pintu) listenAndServe(server *http.Server) error {
+	l, err := listen(server.Addr, p.runtime().settings.socketMode)
+	if err != nil {
+		return err
+	}
+	if server.TLSConfig != nil {
+		return server.ServeTLS(l, "", "")
+	}
+	return server.Serve(l)
+}
+
+func parseSocketMode(mode string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, errSocketMode
+	}
+	return os.FileMode(value), nil
+}
+
+// unixTransport dials the socket in place of the tcp address
+func unixTransport(base *http.Transport, socket string, timeout time.Duration) *http.Transport {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transport := base.Clone()
+	dialer := &net.Dialer{Timeout: timeout}
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, unixScheme, socket)
+	}
+	return transport
+}
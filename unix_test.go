@@ -0,0 +1,110 @@
+package pintu
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// unixClient dials the socket for every request
+func unixClient(socket string) *http.Client {
+	return &http.Client{Transport: unixTransport(nil, socket, 0)}
+}
+
+func TestUnixListener(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "pintu.sock")
+	p := &Pintu{}
+	p.current.Store(&runtime{settings: &Settings{socketMode: 0600}})
+
+	// a socket left by a previous run is replaced
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	server := p.newServer("unix://"+socket, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pong")
+	}))
+	served := make(chan error, 1)
+	go func() { served <- p.listenAndServe(server) }()
+	defer server.Close()
+	eventually(t, "the socket", func() bool {
+		info, err := os.Stat(socket)
+		return err == nil && info.Mode()&os.ModeSocket != 0 && info.Mode().Perm() == 0600
+	})
+
+	resp, err := unixClient(socket).Get("http://pintu/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "pong" {
+		t.Fatalf("GET over the socket = %q", body)
+	}
+	server.Close()
+	if err := <-served; err != http.ErrServerClosed {
+		t.Fatalf("listenAndServe() = %v", err)
+	}
+}
+
+func TestListenKeepsRegularFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pintu.sock")
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix://"+path, 0660); err == nil {
+		t.Fatal("listen() replaced a regular file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "data" {
+		t.Fatal("listen() removed a regular file")
+	}
+}
+
+func TestUnixUpstream(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+r.URL.Path)
+	})}
+	go backend.Serve(l)
+	defer backend.Close()
+
+	p := newTestPool(t, &Upstream{}, "unix://"+socket)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/api/users", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "example.com/api/users" {
+		t.Fatalf("unix upstream answered %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	tests := []struct {
+		mode  string
+		value os.FileMode
+		valid bool
+	}{
+		{"0660", 0660, true},
+		{"600", 0600, true},
+		{"0777", 0777, true},
+		{"1777", 0, false},
+		{"0668", 0, false},
+		{"rw", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		value, err := parseSocketMode(test.mode)
+		if (err == nil) != test.valid || value != test.value {
+			t.Errorf("parseSocketMode(%q) = %o, %v", test.mode, value, err)
+		}
+	}
+}
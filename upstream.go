@@ -0,0 +1,223 @@
+package pintu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	// Upstream routes the requests for Host, any host when empty, under the
+	// Path prefix to the backend URL, Rules further restrict who may reach it
+	// URLs add backends to a pool balanced by Balance, see Pool
+	Upstream struct {
+		Host        string       `json:"host"`
+		Path        string       `json:"path"`
+		URL         string       `json:"url"`
+		URLs        []string     `json:"urls"`
+		StripPrefix bool         `json:"strip_prefix"`
+		Rules       Rules        `json:"rules"`
+		Balance     string       `json:"balance"`
+		MaxFails    int          `json:"max_fails"`
+		FailTimeout Duration     `json:"fail_timeout"`
+		HealthCheck *HealthCheck `json:"health_check"`
+		Transport   *Transport   `json:"transport"`
+		// FlushInterval flushes the streamed responses periodically, negative
+		// flushes after every write, event streams are always flushed
+		FlushInterval Duration `json:"flush_interval"`
+
+		target  *url.URL
+		pool    *Pool
+		handler http.Handler
+	}
+
+	// Upstreams is the upstream routing table
+	Upstreams []*Upstream
+)
+
+var (
+	errUpstreamURL  = errors.New("requires an absolute http url or a unix:///path socket")
+	errUpstreamPath = errors.New("path must start with /")
+)
+
+// Match returns the upstream serving r, a host match beats a catch-all
+// entry then the longest path wins
+func (us Upstreams) Match(r *http.Request) *Upstream {
+	host := strings.ToLower(GetDomain(r))
+	var match *Upstream
+	for _, u := range us {
+		if !u.matchHost(host) || !u.matchPath(r.URL.Path) {
+			continue
+		}
+		if match == nil || u.beats(match) {
+			match = u
+		}
+	}
+	return match
+}
+
+// ServeHTTP proxies the request to its upstream
+func (us Upstreams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := us.Match(r)
+	if u == nil {
+		NotFound(w, r)
+		return
+	}
+	u.handler.ServeHTTP(w, r)
+}
+
+// Allow applies the rules of the upstream serving r
+func (us Upstreams) Allow(r *http.Request, email string) bool {
+	u := us.Match(r)
+	return u == nil || u.Rules.Allow(r.URL.Path, email)
+}
+
+// RequireMFA tells whether the rules of the upstream serving r need the
+// second factor
+func (us Upstreams) RequireMFA(r *http.Request) bool {
+	u := us.Match(r)
+	return u != nil && u.Rules.RequireMFA(r.URL.Path)
+}
+
+func (u *Upstream) matchHost(host string) bool {
+	switch {
+	case u.Host == "":
+		return true
+	case strings.HasPrefix(u.Host, "*."):
+		return strings.HasSuffix(host, u.Host[1:])
+	}
+	return host == u.Host
+}
+
+func (u *Upstream) matchPath(path string) bool {
+	return underPath(path, u.Path)
+}
+
+// underPath matches prefix itself and the paths below it by whole segments,
+// /api covers /api/v1 but not /apikeys, the upstreams and the rules agree on it
+func underPath(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+func (u *Upstream) beats(other *Upstream) bool {
+	if (u.Host != "") != (other.Host != "") {
+		return u.Host != ""
+	}
+	return len(u.Path) > len(other.Path)
+}
+
+// init validates the entry and builds its reverse proxy
+func (u *Upstream) init(option string) error {
+	var errs ValidationErrors
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		errs.Add(InvalidParam(option, errUpstreamPath))
+	}
+	u.Host = strings.ToLower(u.Host)
+
+	var targets []*url.URL
+	for _, raw := range u.backends() {
+		target, err := url.Parse(raw)
+		if err != nil {
+			errs.Add(InvalidParam(option, err))
+		} else if target.Scheme == unixScheme {
+			if target.Path == "" {
+				errs.Add(InvalidParam(option, errSocketPath))
+			} else {
+				targets = append(targets, target)
+			}
+		} else if target.Scheme == "" || target.Host == "" {
+			errs.Add(InvalidParam(option, errUpstreamURL))
+		} else {
+			targets = append(targets, target)
+		}
+	}
+	if len(u.backends()) == 0 {
+		errs.Add(MissingParam(option + ".url"))
+	}
+	transport, err := u.Transport.newTransport(option + ".transport")
+	errs.Add(err)
+	pool, err := newPool(u, targets, transport)
+	if err != nil {
+		errs.Add(InvalidParam(option, err))
+	}
+	errs.Add(u.Rules.validate())
+	if len(errs) > 0 {
+		return errs.Err()
+	}
+
+	u.target = targets[0]
+	u.pool = pool
+	u.handler = pool
+	if u.StripPrefix && u.Path != "/" {
+		u.handler = http.StripPrefix(strings.TrimSuffix(u.Path, "/"), u.handler)
+	}
+	return nil
+}
+
+func (u *Upstream) backends() []string {
+	if u.URL == "" {
+		return u.URLs
+	}
+	return append([]string{u.URL}, u.URLs...)
+}
+
+// Status reports the backends of the upstream
+func (u *Upstream) Status() PoolStatus {
+	status := PoolStatus{Host: u.Host, Path: u.Path}
+	if u.pool == nil {
+		return status
+	}
+	now := time.Now()
+	status.Balance = u.pool.balance
+	for _, b := range u.pool.backends {
+		status.Backends = append(status.Backends, b.status(now))
+	}
+	return status
+}
+
+// init builds every entry of the table
+func (us Upstreams) init() error {
+	var errs ValidationErrors
+	for i, u := range us {
+		errs.Add(u.init(fmt.Sprintf("%s[%d]", configUpstreams, i)))
+	}
+	return errs.Err()
+}
+
+// Status reports the backends of every entry
+func (us Upstreams) Status() []PoolStatus {
+	status := make([]PoolStatus, 0, len(us))
+	for _, u := range us {
+		status = append(status, u.Status())
+	}
+	return status
+}
+
+// start runs the health checks of the table once it is served
+func (us Upstreams) start() {
+	livePoolsMu.Lock()
+	defer livePoolsMu.Unlock()
+	for _, u := range us {
+		livePools[u] = true
+		u.pool.start()
+	}
+}
+
+// Close stops the health checks, the proxies keep serving the requests in
+// flight
+func (us Upstreams) Close() {
+	livePoolsMu.Lock()
+	defer livePoolsMu.Unlock()
+	for _, u := range us {
+		delete(livePools, u)
+		if u.pool != nil {
+			u.pool.Close()
+		}
+	}
+}
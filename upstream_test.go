@@ -0,0 +1,38 @@
+package pintu
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamsMatch(t *testing.T) {
+	routes := Upstreams{
+		{Path: "/", URL: "http://127.0.0.1:8080"},
+		{Path: "/api", URL: "http://127.0.0.1:8081"},
+		{Path: "/kibana/", URL: "http://127.0.0.1:5601"},
+		{Host: "grafana.example.com", URL: "http://127.0.0.1:3000"},
+	}
+	if err := routes.init(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host, path, url string
+	}{
+		{"example.com", "/", "http://127.0.0.1:8080"},
+		{"example.com", "/api", "http://127.0.0.1:8081"},
+		{"example.com", "/api/", "http://127.0.0.1:8081"},
+		{"example.com", "/api/v1/users", "http://127.0.0.1:8081"},
+		{"example.com", "/apikeys", "http://127.0.0.1:8080"},
+		{"example.com", "/api-docs/", "http://127.0.0.1:8080"},
+		{"example.com", "/kibana/app", "http://127.0.0.1:5601"},
+		{"example.com", "/kibanax", "http://127.0.0.1:8080"},
+		{"grafana.example.com", "/api/v1", "http://127.0.0.1:3000"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://"+test.host+test.path, nil)
+		u := routes.Match(r)
+		if u == nil || u.URL != test.url {
+			t.Errorf("Match(%s%s) = %v, want %s", test.host, test.path, u, test.url)
+		}
+	}
+}